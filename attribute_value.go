@@ -1,16 +1,26 @@
 package dynamockdb
 
 import (
-	"fmt"
+	"bytes"
+	"strings"
 )
 
+// Maximum nesting level of maps and lists in an attribute value
+const maxAttributeDepth = 32
+
+// AttributeValue holds a single value of one of the DynamoDB data types.
+// Exactly one field must be set, see json.go for its wire format.
 type AttributeValue struct {
-	B  string
-	BS []string
-	N  string
-	NS []string
-	S  string
-	SS []string
+	B    []byte
+	BS   [][]byte
+	N    string
+	NS   []string
+	S    string
+	SS   []string
+	M    map[string]AttributeValue
+	L    []AttributeValue
+	BOOL *bool
+	NULL bool
 }
 
 func (a *AttributeValue) Value(attributeType AttributeType) string {
@@ -20,53 +30,397 @@ func (a *AttributeValue) Value(attributeType AttributeType) string {
 	case NumberAttributeType:
 		return a.N
 	case BinaryAttributeType:
-		return a.B
+		return string(a.B)
 	default:
 		return ""
 	}
 }
 
 func (a *AttributeValue) ValidateExpectations(attributeType AttributeType, exp ExpectedAttributeValue) error {
+	if !a.Equal(&exp.Value) {
+		return &ConditionalCheckFailedException{Message: "The conditional request failed"}
+	}
+	return nil
+}
+
+// Type returns the type of the value held by a, or an empty string if a
+// holds nothing.
+func (a AttributeValue) Type() AttributeType {
+	switch {
+	case a.S != "":
+		return StringAttributeType
+	case a.N != "":
+		return NumberAttributeType
+	case a.B != nil:
+		return BinaryAttributeType
+	case a.SS != nil:
+		return StringSetAttributeType
+	case a.NS != nil:
+		return NumberSetAttributeType
+	case a.BS != nil:
+		return BinarySetAttributeType
+	case a.M != nil:
+		return MapAttributeType
+	case a.L != nil:
+		return ListAttributeType
+	case a.BOOL != nil:
+		return BooleanAttributeType
+	case a.NULL:
+		return NullAttributeType
+	}
+	return ""
+}
+
+// Equal tells if a and b hold the same value. Numbers are compared by value,
+// sets regardless of the order of their elements.
+func (a *AttributeValue) Equal(b *AttributeValue) bool {
+	attributeType := a.Type()
+	if attributeType != b.Type() {
+		return false
+	}
+
 	switch attributeType {
-	case StringAttributeType:
-		if exp.Value.S != a.S {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+	case StringAttributeType, NumberAttributeType, BinaryAttributeType:
+		return a.Compare(b, attributeType) == 0
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		return equalSets(a.set(), b.set(), elementType(attributeType))
+	case MapAttributeType:
+		if len(a.M) != len(b.M) {
+			return false
 		}
-	case StringSetAttributeType:
-		if len(exp.Value.SS) != len(a.SS) {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		for k, va := range a.M {
+			vb, ok := b.M[k]
+			if !ok || !va.Equal(&vb) {
+				return false
+			}
+		}
+	case ListAttributeType:
+		if len(a.L) != len(b.L) {
+			return false
 		}
-		for i, v := range exp.Value.SS {
-			if v != a.SS[i] {
-				return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		for i := range a.L {
+			if !a.L[i].Equal(&b.L[i]) {
+				return false
 			}
 		}
+	case BooleanAttributeType:
+		return *a.BOOL == *b.BOOL
+	}
+	return true
+}
+
+// Normalize checks the value held by a is valid and returns it with numbers
+// in their canonical form. Binaries must not be empty and sets must be non
+// empty and hold no duplicates.
+//
+// Maps and lists are normalized recursively and can be nested up to 32
+// levels deep.
+func (a AttributeValue) Normalize() (AttributeValue, error) {
+	return a.normalize(1)
+}
+
+func (a AttributeValue) normalize(depth int) (AttributeValue, error) {
+	switch a.Type() {
+	case "":
+		return a, validationErrorf("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
 	case NumberAttributeType:
-		if exp.Value.N != a.N {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		n, err := ParseNumber(a.N)
+		if err != nil {
+			return a, err
 		}
-	case NumberSetAttributeType:
-		if len(exp.Value.NS) != len(a.NS) {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		return AttributeValue{N: n.String()}, nil
+	case BinaryAttributeType:
+		if len(a.B) == 0 {
+			return a, validationErrorf("One or more parameter values were invalid: An AttributeValue may not contain an empty binary")
 		}
-		for i, v := range exp.Value.NS {
-			if v != a.NS[i] {
-				return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		elements, err := normalizeSet(a.set(), elementType(a.Type()))
+		if err != nil {
+			return a, err
+		}
+		return setValue(elements, a.Type()), nil
+	case MapAttributeType:
+		if depth > maxAttributeDepth {
+			return a, validationErrorf("Nesting Levels have exceeded supported limits")
+		}
+		m := make(map[string]AttributeValue, len(a.M))
+		for k, v := range a.M {
+			val, err := v.normalize(depth + 1)
+			if err != nil {
+				return a, err
 			}
+			m[k] = val
+		}
+		return AttributeValue{M: m}, nil
+	case ListAttributeType:
+		if depth > maxAttributeDepth {
+			return a, validationErrorf("Nesting Levels have exceeded supported limits")
+		}
+		l := make([]AttributeValue, len(a.L))
+		for i, v := range a.L {
+			val, err := v.normalize(depth + 1)
+			if err != nil {
+				return a, err
+			}
+			l[i] = val
+		}
+		return AttributeValue{L: l}, nil
+	case BooleanAttributeType:
+		b := *a.BOOL
+		return AttributeValue{BOOL: &b}, nil
+	}
+	return a, nil
+}
+
+// errTypeMismatch is returned by AddTo and DeleteFromSet when the old value
+// does not have the type of the update.
+var errTypeMismatch = validationErrorf("Type mismatch for attribute to update")
+
+// AddTo returns the result of a legacy ADD update of a on old, which is nil
+// if the attribute does not exist yet: numbers are added, set elements are
+// added to the set and list elements are appended to the list.
+func (a AttributeValue) AddTo(old *AttributeValue) (AttributeValue, error) {
+	val, err := a.Normalize()
+	if err != nil {
+		return a, err
+	}
+
+	switch val.Type() {
+	case NumberAttributeType:
+		if old == nil {
+			return val, nil
+		}
+		if old.Type() != NumberAttributeType {
+			return a, errTypeMismatch
+		}
+		n, _ := ParseNumber(val.N)
+		m, err := ParseNumber(old.N)
+		if err != nil {
+			return a, err
+		}
+		sum, err := m.Add(n)
+		if err != nil {
+			return a, err
+		}
+		return AttributeValue{N: sum.String()}, nil
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		if old == nil {
+			return val, nil
+		}
+		if old.Type() != val.Type() {
+			return a, errTypeMismatch
+		}
+		return setValue(unionSet(old.set(), val.set(), val.Type()), val.Type()), nil
+	case ListAttributeType:
+		if old == nil {
+			return val, nil
+		}
+		if old.Type() != ListAttributeType {
+			return a, errTypeMismatch
+		}
+		l := make([]AttributeValue, 0, len(old.L)+len(val.L))
+		return AttributeValue{L: append(append(l, old.L...), val.L...)}, nil
+	}
+	return a, validationErrorf("One or more parameter values were invalid: Action ADD not supported for the type %s", val.Type())
+}
+
+// DeleteFromSet returns the result of a legacy DELETE update of the set a
+// on the set old: the elements of a are removed from old. An empty value is
+// returned if no element is left.
+func (a AttributeValue) DeleteFromSet(old AttributeValue) (AttributeValue, error) {
+	val, err := a.Normalize()
+	if err != nil {
+		return a, err
+	}
+
+	switch val.Type() {
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		if old.Type() != val.Type() {
+			return a, errTypeMismatch
+		}
+		left := differenceSet(old.set(), val.set(), val.Type())
+		if len(left) == 0 {
+			return AttributeValue{}, nil
+		}
+		return setValue(left, val.Type()), nil
+	}
+	return a, validationErrorf("One or more parameter values were invalid: Action DELETE not supported for the type %s", val.Type())
+}
+
+// Compare orders the scalar values of type attributeType held by a and b the
+// way DynamoDB does: numbers by their numeric value, binaries byte per byte
+// and strings by their UTF-8 bytes. It returns -1, 0 or 1
+// when a is respectively lower, equal or greater than b.
+//
+// Values are expected to be valid, see Normalize. Malformed numbers are
+// ordered as strings.
+func (a *AttributeValue) Compare(b *AttributeValue, attributeType AttributeType) int {
+	switch attributeType {
+	case NumberAttributeType:
+		// Stored keys are canonical, most comparisons need no parsing
+		if cmp, ok := compareNumberStrings(a.N, b.N); ok {
+			return cmp
+		}
+		na, errA := ParseNumber(a.N)
+		nb, errB := ParseNumber(b.N)
+		if errA == nil && errB == nil {
+			return na.Cmp(nb)
+		}
+	case BinaryAttributeType:
+		return bytes.Compare(a.B, b.B)
+	}
+	return strings.Compare(a.Value(attributeType), b.Value(attributeType))
+}
+
+// HasPrefix tells if the string or binary value held by a starts with the one
+// held by prefix.
+func (a *AttributeValue) HasPrefix(prefix *AttributeValue, attributeType AttributeType) bool {
+	return strings.HasPrefix(a.Value(attributeType), prefix.Value(attributeType))
+}
+
+// compareValues orders two scalar attribute values of type attributeType.
+func compareValues(a, b AttributeValue, attributeType AttributeType) int {
+	return a.Compare(&b, attributeType)
+}
+
+// equalSets tells if the sets of type attributeType held by a and b have the
+// same elements, in any order.
+func equalSets(a, b []string, attributeType AttributeType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, e := range a {
+		if !setContains(b, e, attributeType) {
+			return false
 		}
+	}
+	return true
+}
+
+// scalarValue wraps v in an AttributeValue of type attributeType.
+func scalarValue(v string, attributeType AttributeType) AttributeValue {
+	switch attributeType {
+	case NumberAttributeType:
+		return AttributeValue{N: v}
 	case BinaryAttributeType:
-		if exp.Value.B != a.B {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		return AttributeValue{B: []byte(v)}
+	}
+	return AttributeValue{S: v}
+}
+
+// set returns the elements of the set held by a.
+func (a *AttributeValue) set() []string {
+	switch a.Type() {
+	case StringSetAttributeType:
+		return a.SS
+	case NumberSetAttributeType:
+		return a.NS
+	case BinarySetAttributeType:
+		elements := make([]string, len(a.BS))
+		for i, b := range a.BS {
+			elements[i] = string(b)
 		}
+		return elements
+	}
+	return nil
+}
+
+// setValue wraps elements in an AttributeValue of the set type setType.
+func setValue(elements []string, setType AttributeType) AttributeValue {
+	switch setType {
+	case NumberSetAttributeType:
+		return AttributeValue{NS: elements}
 	case BinarySetAttributeType:
-		if len(exp.Value.BS) != len(a.BS) {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+		bs := make([][]byte, len(elements))
+		for i, e := range elements {
+			bs[i] = []byte(e)
 		}
-		for i, v := range exp.Value.BS {
-			if v != a.BS[i] {
-				return fmt.Errorf("PuItem: Expectation not met: %v", exp)
-			}
+		return AttributeValue{BS: bs}
+	}
+	return AttributeValue{SS: elements}
+}
+
+// elementType returns the type of the elements of the set type setType.
+func elementType(setType AttributeType) AttributeType {
+	switch setType {
+	case NumberSetAttributeType:
+		return NumberAttributeType
+	case BinarySetAttributeType:
+		return BinaryAttributeType
+	}
+	return StringAttributeType
+}
+
+// normalizeSet checks the elements of type attributeType of a set and
+// returns them in their canonical form.
+func normalizeSet(elements []string, attributeType AttributeType) ([]string, error) {
+	if len(elements) == 0 {
+		return nil, validationErrorf("One or more parameter values were invalid: An %s set may not be empty", attributeType)
+	}
+	normalized := make([]string, 0, len(elements))
+	for _, e := range elements {
+		val, err := scalarValue(e, attributeType).Normalize()
+		if err != nil {
+			return nil, err
 		}
+		e = val.Value(attributeType)
+		if setContains(normalized, e, attributeType) {
+			return nil, validationErrorf("One or more parameter values were invalid: Input collection %v contains duplicates", elements)
+		}
+		normalized = append(normalized, e)
 	}
-	return nil
+	return normalized, nil
+}
+
+// setContains tells if the set of elements of type attributeType holds e.
+func setContains(elements []string, e string, attributeType AttributeType) bool {
+	v := scalarValue(e, attributeType)
+	for _, other := range elements {
+		if compareValues(scalarValue(other, attributeType), v, attributeType) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func unionSet(a, b []string, setType AttributeType) []string {
+	union := append([]string{}, a...)
+	for _, e := range b {
+		if !setContains(a, e, elementType(setType)) {
+			union = append(union, e)
+		}
+	}
+	return union
+}
+
+func differenceSet(a, b []string, setType AttributeType) []string {
+	difference := make([]string, 0, len(a))
+	for _, e := range a {
+		if !setContains(b, e, elementType(setType)) {
+			difference = append(difference, e)
+		}
+	}
+	return difference
+}
+
+// normalizeItem checks all the attribute values of item and returns a copy
+// of it with values in their canonical form.
+func normalizeItem(item map[string]AttributeValue) (map[string]AttributeValue, error) {
+	normalized := make(map[string]AttributeValue, len(item))
+	for k, v := range item {
+		val, err := v.Normalize()
+		if err != nil {
+			return nil, err
+		}
+		normalized[k] = val
+	}
+	return normalized, nil
+}
+
+func copyItem(item map[string]AttributeValue) map[string]AttributeValue {
+	c := make(map[string]AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	return c
 }
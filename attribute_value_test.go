@@ -0,0 +1,65 @@
+package dynamockdb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b          AttributeValue
+		attributeType AttributeType
+		expected      int
+	}{
+		{AttributeValue{N: "9"}, AttributeValue{N: "10"}, NumberAttributeType, -1},
+		{AttributeValue{N: "-10"}, AttributeValue{N: "-9"}, NumberAttributeType, -1},
+		{AttributeValue{N: "1.50"}, AttributeValue{N: "1.5"}, NumberAttributeType, 0},
+		{AttributeValue{N: "1E+2"}, AttributeValue{N: "99.999999999999999999999999999999999999"}, NumberAttributeType, 1},
+		{AttributeValue{N: "12345678901234567890123456789012345678"}, AttributeValue{N: "12345678901234567890123456789012345677"}, NumberAttributeType, 1},
+		{AttributeValue{N: "1E-130"}, AttributeValue{N: "0"}, NumberAttributeType, 1},
+		{AttributeValue{S: "a"}, AttributeValue{S: "b"}, StringAttributeType, -1},
+		{AttributeValue{S: "Z"}, AttributeValue{S: "a"}, StringAttributeType, -1},
+		{AttributeValue{S: "é"}, AttributeValue{S: "z"}, StringAttributeType, 1},
+		{AttributeValue{B: []byte{0x00}}, AttributeValue{B: []byte{0xff}}, BinaryAttributeType, -1},
+		{AttributeValue{B: []byte{0x00, 0x01}}, AttributeValue{B: []byte{0x00}}, BinaryAttributeType, 1},
+	}
+
+	for _, c := range cases {
+		if actual := c.a.Compare(&c.b, c.attributeType); actual != c.expected {
+			t.Fatalf("%+v compared to %+v: expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := c.b.Compare(&c.a, c.attributeType); actual != -c.expected {
+			t.Fatalf("%+v compared to %+v: expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestValidateExpectationsSets(t *testing.T) {
+	a := AttributeValue{NS: []string{"1", "2.0", "3"}}
+	exp := ExpectedAttributeValue{Exists: true, Value: AttributeValue{NS: []string{"3", "2", "1.00"}}}
+	if err := a.ValidateExpectations(NumberSetAttributeType, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	exp.Value.NS = []string{"3", "2", "4"}
+	if err := a.ValidateExpectations(NumberSetAttributeType, exp); err == nil {
+		t.Fatalf("sets should differ")
+	}
+}
+
+func TestDocumentJSON(t *testing.T) {
+	var item map[string]AttributeValue
+	data := `{"doc": {"M": {"a": {"L": [{"S": "x"}, {"BOOL": false}, {"NULL": true}]}, "b": {"M": {}}}}}`
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := item["doc"]
+	if doc.Type() != MapAttributeType || doc.M["b"].Type() != MapAttributeType {
+		t.Fatalf("wrong types %+v", doc)
+	}
+	l := doc.M["a"].L
+	if len(l) != 3 || l[0].S != "x" || l[1].Type() != BooleanAttributeType || *l[1].BOOL || l[2].Type() != NullAttributeType {
+		t.Fatalf("wrong list %+v", l)
+	}
+}
@@ -1,3 +1,364 @@
 package dynamockdb
 
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
 
+const (
+	maxBatchGetKeys     = 100
+	maxBatchGetResponse = 16 << 20 // Bytes returned by a BatchGetItem
+	maxBatchWrites      = 25
+)
+
+// UnprocessedPolicy makes BatchGetItem and BatchWriteItem leave some of
+// their items unprocessed, as DynamoDB does when a table is throttled, so
+// that client retry loops can be tested. When a whole batch is left
+// unprocessed, a ProvisionedThroughputExceededException is returned instead.
+type UnprocessedPolicy struct {
+	// Every leaves every Nth item of each batch unprocessed, counting the
+	// items of all tables in table name order. Zero disables it.
+	Every int
+	// Fraction is the probability for each item to be left unprocessed.
+	Fraction float64
+	// Seed seeds the random draws of Fraction so runs can be replayed.
+	Seed int64
+}
+
+// SetUnprocessedPolicy sets the policy batch calls follow. The zero policy
+// processes everything.
+func (db *DB) SetUnprocessedPolicy(policy UnprocessedPolicy) {
+	db.unprocessed.mu.Lock()
+	defer db.unprocessed.mu.Unlock()
+	db.unprocessed.policy = policy
+	db.unprocessed.rand = rand.New(rand.NewSource(policy.Seed))
+}
+
+type unprocessedSimulator struct {
+	mu     sync.Mutex
+	policy UnprocessedPolicy
+	rand   *rand.Rand
+}
+
+// pick tells for each of the n items of a batch if it is left unprocessed.
+// It fails if none of them is processed.
+func (u *unprocessedSimulator) pick(n int) ([]bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	skip := make([]bool, n)
+	skipped := 0
+	for i := range skip {
+		every := u.policy.Every > 0 && (i+1)%u.policy.Every == 0
+		random := u.policy.Fraction > 0 && u.rand.Float64() < u.policy.Fraction
+		if every || random {
+			skip[i] = true
+			skipped++
+		}
+	}
+	if n > 0 && skipped == n {
+		return nil, &ProvisionedThroughputExceededException{Message: "The level of configured provisioned throughput for the table was exceeded. Consider increasing your provisioning level with the UpdateTable API."}
+	}
+	return skip, nil
+}
+
+// BatchGetItem reads items from several tables. Tables are read one after
+// the other, the batch as a whole is not isolated from concurrent writes.
+// Keys that don't fit in the response are returned in UnprocessedKeys.
+func (db *DB) BatchGetItem(req *BatchGetItemRequest) (*BatchGetItemResult, error) {
+	tables, err := db.batchGetTables(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchGetItemResult{
+		Responses:       make(map[string][]map[string]AttributeValue),
+		UnprocessedKeys: make(map[string]*KeysAndAttributes),
+	}
+	tableNames := sortedTableNames(tables)
+	total := 0
+	for _, keys := range req.RequestItems {
+		total += len(keys.Keys)
+	}
+	skip, err := db.unprocessed.pick(total)
+	if err != nil {
+		return nil, err
+	}
+
+	size, i := 0, 0
+	for _, tableName := range tableNames {
+		table, keys := tables[tableName], req.RequestItems[tableName]
+		process := &KeysAndAttributes{
+			AttributesToGet:          keys.AttributesToGet,
+			ConsistentRead:           keys.ConsistentRead,
+			ExpressionAttributeNames: keys.ExpressionAttributeNames,
+			ProjectionExpression:     keys.ProjectionExpression,
+		}
+		var unprocessed []map[string]AttributeValue
+		for _, key := range keys.Keys {
+			if skip[i] {
+				unprocessed = append(unprocessed, key)
+			} else {
+				process.Keys = append(process.Keys, key)
+			}
+			i++
+		}
+
+		items, rest, consumed := table.batchGet(process, &size)
+		unprocessed = append(unprocessed, rest...)
+		result.Responses[tableName] = items
+		if len(unprocessed) > 0 {
+			result.UnprocessedKeys[tableName] = &KeysAndAttributes{
+				Keys:                     unprocessed,
+				AttributesToGet:          keys.AttributesToGet,
+				ConsistentRead:           keys.ConsistentRead,
+				ExpressionAttributeNames: keys.ExpressionAttributeNames,
+				ProjectionExpression:     keys.ProjectionExpression,
+			}
+		}
+		if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
+			result.ConsumedCapacity = append(result.ConsumedCapacity, ConsumedCapacity{CapacityUnits: consumed, TableName: tableName})
+		}
+	}
+
+	return result, nil
+}
+
+// batchGetTables validates req and returns the tables it reads from.
+func (db *DB) batchGetTables(req *BatchGetItemRequest) (map[string]*Table, error) {
+	if len(req.RequestItems) == 0 {
+		return nil, validationErrorf("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must have length greater than or equal to 1")
+	}
+
+	tables := make(map[string]*Table, len(req.RequestItems))
+	total := 0
+	for tableName, keys := range req.RequestItems {
+		table, err := db.table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		tables[tableName] = table
+
+		if keys == nil || len(keys.Keys) == 0 {
+			return nil, validationErrorf("1 validation error detected: Value '[]' at 'requestItems.%s.member.keys' failed to satisfy constraint: Member must have length greater than or equal to 1", tableName)
+		}
+		total += len(keys.Keys)
+		if total > maxBatchGetKeys {
+			return nil, validationErrorf("Too many items requested for the BatchGetItem call")
+		}
+
+		seen := make(map[string]bool, len(keys.Keys))
+		for _, key := range keys.Keys {
+			parsed, err := table.parseKey(key)
+			if err != nil {
+				return nil, err
+			}
+			k := table.itemID(parsed)
+			if seen[k] {
+				return nil, validationErrorf("Provided list of item keys contains duplicates")
+			}
+			seen[k] = true
+		}
+		if _, err := keys.projection(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// projection returns the paths of the items to return, nil for the whole
+// items.
+func (keys *KeysAndAttributes) projection() ([]documentPath, error) {
+	attrs := newExpressionAttributes(keys.ExpressionAttributeNames, nil)
+	paths, err := parseReadProjection(keys.AttributesToGet, keys.ProjectionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return paths, attrs.checkUnused()
+}
+
+// batchGet reads the items at keys.Keys. size is the size of the batch
+// response so far, keys left once it goes over the limit are returned as
+// unprocessed.
+func (t *Table) batchGet(keys *KeysAndAttributes, size *int) ([]map[string]AttributeValue, []map[string]AttributeValue, float64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// The projection was validated with the batch
+	paths, _ := keys.projection()
+	items := make([]map[string]AttributeValue, 0, len(keys.Keys))
+	consumed := 0.0
+	for i, attrs := range keys.Keys {
+		if *size >= maxBatchGetResponse {
+			return items, keys.Keys[i:], consumed
+		}
+		key, _ := t.keyOf(attrs)
+		item := t.lookup(key)
+		if item == nil {
+			consumed += readCapacity(0, keys.ConsistentRead)
+			continue
+		}
+		n := itemSize(item)
+		*size += n
+		consumed += readCapacity(n, keys.ConsistentRead)
+		items = append(items, projectItem(item, paths))
+	}
+	return items, nil, consumed
+}
+
+// BatchWriteItem puts and deletes items in several tables. The whole batch
+// is validated before anything is written, then tables are written one
+// after the other. Each write is atomic but the batch as a whole is not.
+func (db *DB) BatchWriteItem(req *BatchWriteItemRequest) (*BatchWriteItemResult, error) {
+	tables, err := db.batchWriteTables(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tableNames := sortedTableNames(tables)
+
+	total := 0
+	for _, writes := range req.RequestItems {
+		total += len(writes)
+	}
+	skip, err := db.unprocessed.pick(total)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchWriteItemResult{UnprocessedItems: make(map[string][]WriteRequest)}
+	i := 0
+	for _, tableName := range tableNames {
+		table := tables[tableName]
+		var process []WriteRequest
+		for _, write := range req.RequestItems[tableName] {
+			if skip[i] {
+				result.UnprocessedItems[tableName] = append(result.UnprocessedItems[tableName], write)
+			} else {
+				process = append(process, write)
+			}
+			i++
+		}
+
+		consumed, metrics, conflicts := table.batchWrite(process)
+		if len(conflicts) > 0 {
+			result.UnprocessedItems[tableName] = append(result.UnprocessedItems[tableName], conflicts...)
+		}
+		if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
+			result.ConsumedCapacity = append(result.ConsumedCapacity, ConsumedCapacity{CapacityUnits: consumed, TableName: tableName})
+		}
+		// Only tables with local secondary indexes have item collections
+		if req.ReturnItemCollectionMetrics == SizeReturnItemCollectionMetrics && len(table.TableDescription.LocalSecondaryIndexes) > 0 {
+			if result.ItemCollectionMetrics == nil {
+				result.ItemCollectionMetrics = make(map[string][]ItemCollectionMetrics)
+			}
+			result.ItemCollectionMetrics[tableName] = metrics
+		}
+	}
+
+	return result, nil
+}
+
+// batchWriteTables validates req and returns the tables it writes to.
+func (db *DB) batchWriteTables(req *BatchWriteItemRequest) (map[string]*Table, error) {
+	if len(req.RequestItems) == 0 {
+		return nil, validationErrorf("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must have length greater than or equal to 1")
+	}
+
+	tables := make(map[string]*Table, len(req.RequestItems))
+	total := 0
+	for tableName, writes := range req.RequestItems {
+		table, err := db.table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		tables[tableName] = table
+
+		if len(writes) == 0 {
+			return nil, validationErrorf("1 validation error detected: Value '[]' at 'requestItems.%s.member' failed to satisfy constraint: Member must have length greater than or equal to 1", tableName)
+		}
+		total += len(writes)
+		if total > maxBatchWrites {
+			return nil, validationErrorf("Too many items requested for the BatchWriteItem call")
+		}
+
+		seen := make(map[string]bool, len(writes))
+		for _, write := range writes {
+			var key itemKey
+			switch {
+			case write.PutRequest != nil && write.DeleteRequest == nil:
+				if key, err = table.keyOf(write.PutRequest.Item); err == nil {
+					_, err = normalizeItem(write.PutRequest.Item)
+				}
+			case write.DeleteRequest != nil && write.PutRequest == nil:
+				key, err = table.parseKey(write.DeleteRequest.Key)
+			default:
+				err = validationErrorf("Supplied WriteRequest must contain exactly one of PutRequest or DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+			k := table.itemID(key)
+			if seen[k] {
+				return nil, validationErrorf("Provided list of item keys contains duplicates")
+			}
+			seen[k] = true
+		}
+	}
+	return tables, nil
+}
+
+// batchWrite applies the validated writes and returns the capacity they
+// consumed and the metrics of the item collections they touched. Writes to
+// items held by transactions are not applied but returned.
+func (t *Table) batchWrite(writes []WriteRequest) (float64, []ItemCollectionMetrics, []WriteRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	consumed := 0.0
+	var conflicts []WriteRequest
+	var collections []itemKey // A key per item collection written to
+	seen := make(map[string]bool)
+	tableName := t.TableDescription.TableName
+	for _, write := range writes {
+		var key itemKey
+		if write.PutRequest != nil {
+			key, _ = t.keyOf(write.PutRequest.Item)
+		} else {
+			key, _ = t.keyOf(write.DeleteRequest.Key)
+		}
+		if t.heldByTransaction(key) {
+			conflicts = append(conflicts, write)
+			continue
+		}
+
+		if write.PutRequest != nil {
+			old := t.lookup(key)
+			t.putItem(&PutItemRequest{Item: write.PutRequest.Item, TableName: tableName})
+			consumed += writeCapacity(maxInt(itemSize(old), itemSize(t.lookup(key))))
+		} else {
+			result, _ := t.deleteItem(&DeleteItemRequest{Key: write.DeleteRequest.Key, TableName: tableName})
+			consumed += writeCapacity(itemSize(result.Attributes))
+		}
+		if !seen[key.hash] {
+			seen[key.hash] = true
+			collections = append(collections, key)
+		}
+	}
+
+	metrics := make([]ItemCollectionMetrics, 0, len(collections))
+	for _, key := range collections {
+		metrics = append(metrics, t.itemCollectionMetrics(key))
+	}
+	return consumed, metrics, conflicts
+}
+
+func sortedTableNames(tables map[string]*Table) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
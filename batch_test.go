@@ -0,0 +1,291 @@
+package dynamockdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBatchGetItem(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	CreateRangeTable(db, "box")
+	bax, box := db.GetTable("bax"), db.GetTable("box")
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}})
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "ba"}, "foo": AttributeValue{S: "bar"}})
+	InsertItem(box, "box", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: "bat"}})
+
+	result, err := db.BatchGetItem(&BatchGetItemRequest{
+		RequestItems: map[string]*KeysAndAttributes{
+			"bax": &KeysAndAttributes{Keys: []map[string]AttributeValue{
+				{"id": AttributeValue{S: "bar"}},
+				{"id": AttributeValue{S: "ba"}},
+				{"id": AttributeValue{S: "missing"}},
+			}},
+			"box": &KeysAndAttributes{
+				Keys:            []map[string]AttributeValue{{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}}},
+				AttributesToGet: []string{"foo"},
+				ConsistentRead:  true,
+			},
+		},
+		ReturnConsumedCapacity: TotalReturnConsumedCapacity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectItems(t, result.Responses["bax"], "foo", "bam", "bar")
+	ExpectItems(t, result.Responses["box"], "foo", "bat")
+	if len(result.Responses["box"][0]) != 1 {
+		t.Fatalf("only foo should be returned, got %+v", result.Responses["box"][0])
+	}
+	if len(result.UnprocessedKeys) != 0 {
+		t.Fatalf("no keys should be unprocessed, got %+v", result.UnprocessedKeys)
+	}
+	expectedCapacity := []ConsumedCapacity{{CapacityUnits: 1.5, TableName: "bax"}, {CapacityUnits: 1, TableName: "box"}}
+	if fmt.Sprint(result.ConsumedCapacity) != fmt.Sprint(expectedCapacity) {
+		t.Fatalf("wrong consumed capacity %+v", result.ConsumedCapacity)
+	}
+
+	// Invalid batches
+
+	var notFound *ResourceNotFoundException
+	_, err = db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{
+		"nope": &KeysAndAttributes{Keys: []map[string]AttributeValue{{"id": AttributeValue{S: "bar"}}}},
+	}})
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ResourceNotFoundException, got %v", err)
+	}
+
+	_, err = db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{
+		"bax": &KeysAndAttributes{Keys: []map[string]AttributeValue{{"id": AttributeValue{S: "bar"}}, {"id": AttributeValue{S: "bar"}}}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "duplicates") {
+		t.Fatalf("expected duplicate keys to be rejected, got %v", err)
+	}
+
+	keys := make([]map[string]AttributeValue, 101)
+	for i := range keys {
+		keys[i] = map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}}
+	}
+	_, err = db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"bax": &KeysAndAttributes{Keys: keys}}})
+	if err == nil || !strings.Contains(err.Error(), "Too many items") {
+		t.Fatalf("expected too many items, got %v", err)
+	}
+
+	_, err = db.BatchGetItem(&BatchGetItemRequest{})
+	if err == nil {
+		t.Fatalf("empty batch should be rejected")
+	}
+}
+
+func TestBatchGetItemResponseSize(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	big := strings.Repeat("x", 350*1024)
+	keys := make([]map[string]AttributeValue, 60)
+	for i := range keys {
+		keys[i] = map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}}
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}, "foo": AttributeValue{S: big}})
+	}
+
+	result, err := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{
+		"bax": &KeysAndAttributes{Keys: keys, AttributesToGet: []string{"id"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Items count for their full size, 47 items of 350KB go over 16MB
+	got, unprocessed := len(result.Responses["bax"]), result.UnprocessedKeys["bax"]
+	if got != 47 || unprocessed == nil || len(unprocessed.Keys) != 13 {
+		t.Fatalf("expected 47 items and 13 unprocessed keys, got %d and %+v", got, unprocessed)
+	}
+	if unprocessed.Keys[0]["id"].S != "47" || unprocessed.AttributesToGet[0] != "id" {
+		t.Fatalf("wrong unprocessed keys %+v", unprocessed)
+	}
+}
+
+func TestBatchWriteItem(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	CreateRangeTable(db, "box")
+	bax, box := db.GetTable("bax"), db.GetTable("box")
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "old"}, "foo": AttributeValue{S: "bam"}})
+
+	result, err := db.BatchWriteItem(&BatchWriteItemRequest{
+		RequestItems: map[string][]WriteRequest{
+			"bax": {
+				{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bar"}}}},
+				{DeleteRequest: &DeleteRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "old"}}}},
+			},
+			"box": {
+				{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: strings.Repeat("x", 2000)}}}},
+			},
+		},
+		ReturnConsumedCapacity:      TotalReturnConsumedCapacity,
+		ReturnItemCollectionMetrics: SizeReturnItemCollectionMetrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.UnprocessedItems) != 0 || result.ItemCollectionMetrics != nil {
+		t.Fatalf("wrong result %+v", result)
+	}
+	expectedCapacity := []ConsumedCapacity{{CapacityUnits: 2, TableName: "bax"}, {CapacityUnits: 2, TableName: "box"}}
+	if fmt.Sprint(result.ConsumedCapacity) != fmt.Sprint(expectedCapacity) {
+		t.Fatalf("wrong consumed capacity %+v", result.ConsumedCapacity)
+	}
+
+	scan, _ := bax.Scan(&ScanRequest{TableName: "bax"})
+	ExpectItems(t, scan.Items, "id", "bar")
+	scan, _ = box.Scan(&ScanRequest{TableName: "box"})
+	ExpectItems(t, scan.Items, "id", "bar")
+
+	// Invalid batches write nothing
+
+	invalid := []map[string][]WriteRequest{
+		// Two writes on the same key
+		{"bax": {
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}},
+			{DeleteRequest: &DeleteRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}},
+		}},
+		// Invalid item after a valid one
+		{"bax": {
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}},
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "other"}, "n": AttributeValue{N: "1O"}}}},
+		}},
+		// Put and delete in one request
+		{"bax": {
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}, DeleteRequest: &DeleteRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}},
+		}},
+		// Unknown table
+		{"bax": {{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}}}, "nope": {{DeleteRequest: &DeleteRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}}}},
+	}
+	var tooMany []WriteRequest
+	for i := 0; i < 26; i++ {
+		tooMany = append(tooMany, WriteRequest{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}}}})
+	}
+	invalid = append(invalid, map[string][]WriteRequest{"bax": tooMany})
+
+	for _, requestItems := range invalid {
+		if _, err := db.BatchWriteItem(&BatchWriteItemRequest{RequestItems: requestItems}); err == nil {
+			t.Fatalf("batch should be rejected %+v", requestItems)
+		}
+		scan, _ = bax.Scan(&ScanRequest{TableName: "bax"})
+		ExpectItems(t, scan.Items, "id", "bar")
+	}
+}
+
+func TestBatchWriteItemCollectionMetrics(t *testing.T) {
+	db := NewDB()
+	_, err := db.CreateTable(&CreateTableRequest{
+		AttributeDefinitions:  []AttributeDefinition{{AttributeName: "id", AttributeType: StringAttributeType}, {AttributeName: "date", AttributeType: StringAttributeType}, {AttributeName: "foo", AttributeType: StringAttributeType}},
+		KeySchema:             []KeySchemaElement{{AttributeName: "id", KeyType: HashKeyType}, {AttributeName: "date", KeyType: RangeKeyType}},
+		LocalSecondaryIndexes: []LocalSecondaryIndex{{IndexName: "fooIndex", KeySchema: []KeySchemaElement{{AttributeName: "id", KeyType: HashKeyType}, {AttributeName: "foo", KeyType: RangeKeyType}}, Projection: Projection{ProjectionType: AllProjectionType}}},
+		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
+		TableName:             "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.BatchWriteItem(&BatchWriteItemRequest{
+		RequestItems: map[string][]WriteRequest{"bax": {
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: "a"}}}},
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "baz"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: "a"}}}},
+			{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2014"}, "foo": AttributeValue{S: "b"}}}},
+		}},
+		ReturnItemCollectionMetrics: SizeReturnItemCollectionMetrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One entry per item collection written to
+	metrics := result.ItemCollectionMetrics["bax"]
+	if len(metrics) != 2 || metrics[0].ItemCollectionKey["id"].S != "bar" || metrics[1].ItemCollectionKey["id"].S != "baz" || len(metrics[0].ItemCollectionKey) != 1 || fmt.Sprint(metrics[0].SizeEstimateRangeGB) != "[0 1]" {
+		t.Fatalf("wrong item collection metrics %+v", metrics)
+	}
+}
+
+func TestBatchUnprocessedPolicy(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	CreateTable(db, "box")
+	db.SetUnprocessedPolicy(UnprocessedPolicy{Every: 3})
+
+	requestItems := map[string][]WriteRequest{}
+	for i := 0; i < 5; i++ {
+		for _, tableName := range []string{"bax", "box"} {
+			requestItems[tableName] = append(requestItems[tableName], WriteRequest{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}}}})
+		}
+	}
+
+	// Items 3, 6 and 9 of the batch, in table name order, are left
+	result, err := db.BatchWriteItem(&BatchWriteItemRequest{RequestItems: requestItems})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(len(result.UnprocessedItems["bax"]), len(result.UnprocessedItems["box"])) != "1 2" || result.UnprocessedItems["bax"][0].PutRequest.Item["id"].S != "2" {
+		t.Fatalf("wrong unprocessed items %+v", result.UnprocessedItems)
+	}
+	scan, _ := db.GetTable("bax").Scan(&ScanRequest{TableName: "bax"})
+	ExpectItems(t, scan.Items, "id", "0", "1", "3", "4")
+
+	// Resubmitting the unprocessed items eventually writes everything
+	for retries := 0; len(result.UnprocessedItems) > 0; retries++ {
+		if retries > 3 {
+			t.Fatalf("too many retries")
+		}
+		result, err = db.BatchWriteItem(&BatchWriteItemRequest{RequestItems: result.UnprocessedItems})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan, _ = db.GetTable("box").Scan(&ScanRequest{TableName: "box"})
+	ExpectItems(t, scan.Items, "id", "0", "1", "2", "3", "4")
+
+	keys := &KeysAndAttributes{}
+	for i := 0; i < 5; i++ {
+		keys.Keys = append(keys.Keys, map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}})
+	}
+	getResult, err := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"box": keys}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getResult.Responses["box"]) != 4 || len(getResult.UnprocessedKeys["box"].Keys) != 1 || getResult.UnprocessedKeys["box"].Keys[0]["id"].S != "2" {
+		t.Fatalf("wrong unprocessed keys %+v", getResult.UnprocessedKeys)
+	}
+
+	// Nothing processed is a throughput error
+
+	db.SetUnprocessedPolicy(UnprocessedPolicy{Every: 1})
+	_, err = db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"box": keys}})
+	var throttled *ProvisionedThroughputExceededException
+	if !errors.As(err, &throttled) {
+		t.Fatalf("expected ProvisionedThroughputExceededException, got %v", err)
+	}
+
+	// Random draws are replayed with the same seed
+
+	unprocessed := func() string {
+		db.SetUnprocessedPolicy(UnprocessedPolicy{Fraction: 0.5, Seed: 42})
+		result, err := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"box": keys}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.UnprocessedKeys["box"] == nil {
+			return ""
+		}
+		return fmt.Sprint(result.UnprocessedKeys["box"].Keys)
+	}
+	if unprocessed() != unprocessed() {
+		t.Fatalf("same seed should leave the same keys unprocessed")
+	}
+
+	db.SetUnprocessedPolicy(UnprocessedPolicy{})
+	getResult, _ = db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"box": keys}})
+	if len(getResult.Responses["box"]) != 5 || len(getResult.UnprocessedKeys) != 0 {
+		t.Fatalf("everything should be processed, got %+v", getResult)
+	}
+}
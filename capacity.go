@@ -0,0 +1,87 @@
+package dynamockdb
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	readUnitSize  = 4096 // Bytes read per capacity unit
+	writeUnitSize = 1024 // Bytes written per capacity unit
+)
+
+// itemSize returns the size of item the way DynamoDB counts it: the length
+// of the attribute names plus the size of their values.
+func itemSize(item map[string]AttributeValue) int {
+	size := 0
+	for name, v := range item {
+		size += len(name) + v.size()
+	}
+	return size
+}
+
+// size returns the number of bytes a counts for in an item.
+func (a AttributeValue) size() int {
+	switch a.Type() {
+	case StringAttributeType:
+		return len(a.S)
+	case NumberAttributeType:
+		return numberSize(a.N)
+	case BinaryAttributeType:
+		return len(a.B)
+	case StringSetAttributeType:
+		size := 0
+		for _, s := range a.SS {
+			size += len(s)
+		}
+		return size
+	case NumberSetAttributeType:
+		size := 0
+		for _, n := range a.NS {
+			size += numberSize(n)
+		}
+		return size
+	case BinarySetAttributeType:
+		size := 0
+		for _, b := range a.BS {
+			size += len(b)
+		}
+		return size
+	case MapAttributeType:
+		size := 3
+		for name, v := range a.M {
+			size += len(name) + v.size() + 1
+		}
+		return size
+	case ListAttributeType:
+		size := 3
+		for _, v := range a.L {
+			size += v.size() + 1
+		}
+		return size
+	case BooleanAttributeType, NullAttributeType:
+		return 1
+	}
+	return 0
+}
+
+// numberSize is about one byte per two significant digits, plus one.
+func numberSize(n string) int {
+	digits := strings.Trim(strings.Replace(strings.TrimLeft(n, "+-"), ".", "", 1), "0")
+	return (len(digits)+1)/2 + 1
+}
+
+// readCapacity returns the capacity units consumed reading size bytes.
+// Eventually consistent reads cost half.
+func readCapacity(size int, consistent bool) float64 {
+	units := math.Max(1, math.Ceil(float64(size)/readUnitSize))
+	if !consistent {
+		return units / 2
+	}
+	return units
+}
+
+// writeCapacity returns the capacity units consumed writing size bytes.
+func writeCapacity(size int) float64 {
+	return math.Max(1, math.Ceil(float64(size)/writeUnitSize))
+}
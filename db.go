@@ -2,10 +2,21 @@ package dynamockdb
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"sync"
 )
 
+var tableNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// DB is safe for concurrent use. Tables should be accessed through its
+// methods once the DB is shared.
 type DB struct {
-	Tables map[string]*Table
+	Tables      map[string]*Table
+	mu          sync.RWMutex
+	unprocessed unprocessedSimulator // See batch.go
+	tokens      clientTokens         // See transaction.go
+	items       itemLocks            // See transaction.go
 }
 
 func NewDB() *DB {
@@ -13,58 +24,118 @@ func NewDB() *DB {
 }
 
 func (db *DB) GetTable(tableName string) *Table {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return db.Tables[tableName]
 }
 
-func (db *DB) CreateTable(req *CreateTableRequest) *CreateTableResult {
-	fmt.Printf("%+v\n", req)
-	db.Tables[req.TableName] = NewTable(req)
-	return &CreateTableResult{db.Tables[req.TableName].TableDescription}
+// table returns the table named tableName or a ResourceNotFoundException.
+func (db *DB) table(tableName string) (*Table, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	table, ok := db.Tables[tableName]
+	if !ok {
+		return nil, tableNotFound(tableName)
+	}
+	return table, nil
 }
 
-func (db *DB) DescribeTable(req *DescribeTableRequest) *DescribeTableResult {
-	return &DescribeTableResult{db.Tables[req.TableName].TableDescription}
+func (db *DB) CreateTable(req *CreateTableRequest) (*CreateTableResult, error) {
+	if err := validateCreateTable(req); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.Tables[req.TableName]; found {
+		return nil, &ResourceInUseException{Message: fmt.Sprintf("Table already exists: %s", req.TableName)}
+	}
+	table := NewTable(req)
+	table.items = &db.items
+	db.Tables[req.TableName] = table
+	return &CreateTableResult{table.TableDescription}, nil
 }
 
-func (db *DB) ListTables(req *ListTablesRequest) ListTablesResult {
-	total := len(db.Tables)
-	tableNames := make([]string, 0, total)
-	lastTableName := ""
-	count := 0
-	totalCount := 0
-	passedStartTableName := false
-	for tableName, _ := range db.Tables {
-		if req.ExclusiveStartTableName != "" {
-			if passedStartTableName {
-				count += 1
-				tableNames = append(tableNames, tableName)
-			} else {
-				if tableName == req.ExclusiveStartTableName {
-					passedStartTableName = true
-				}
+// validateCreateTable checks the table name and that the key schema is
+// usable: a hash key, at most one range key, both defined in the attribute
+// definitions.
+func validateCreateTable(req *CreateTableRequest) error {
+	if len(req.TableName) < 3 || len(req.TableName) > 255 || !tableNameRegexp.MatchString(req.TableName) {
+		return validationErrorf("TableName must be at least 3 characters long and at most 255 characters long, and match the pattern [a-zA-Z0-9_.-]+")
+	}
+
+	hashKeys, rangeKeys := 0, 0
+	for _, el := range req.KeySchema {
+		switch el.KeyType {
+		case HashKeyType:
+			hashKeys++
+		case RangeKeyType:
+			rangeKeys++
+		default:
+			return validationErrorf("Invalid KeyType: %s", el.KeyType)
+		}
+		defined := false
+		for _, def := range req.AttributeDefinitions {
+			if def.AttributeName == el.AttributeName {
+				defined = true
 			}
-		} else {
-			count += 1
-			tableNames = append(tableNames, tableName)
 		}
-		totalCount += 1
-		lastTableName = tableName
-		if req.Limit > 0 && req.Limit == count {
-			break
+		if !defined {
+			return validationErrorf("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s]", el.AttributeName)
 		}
 	}
+	if hashKeys != 1 || rangeKeys > 1 {
+		return validationErrorf("Invalid KeySchema: The key schema must have exactly one HASH key and at most one RANGE key")
+	}
+	return nil
+}
 
-	if total == totalCount {
-		lastTableName = ""
+func (db *DB) DescribeTable(req *DescribeTableRequest) (*DescribeTableResult, error) {
+	table, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
 	}
-	return ListTablesResult{TableNames: tableNames, LastEvaluatedTableName: lastTableName}
+	return &DescribeTableResult{table.description()}, nil
+}
+
+func (db *DB) ListTables(req *ListTablesRequest) (*ListTablesResult, error) {
+	if req.Limit < 0 || req.Limit > 100 {
+		return nil, validationErrorf("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value less than or equal to 100 and greater than or equal to 1", req.Limit)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Tables are listed by name so pages can resume after the last one
+	names := make([]string, 0, len(db.Tables))
+	for tableName := range db.Tables {
+		if tableName > req.ExclusiveStartTableName {
+			names = append(names, tableName)
+		}
+	}
+	sort.Strings(names)
+
+	result := &ListTablesResult{TableNames: names}
+	if req.Limit > 0 && len(names) > req.Limit {
+		result.TableNames = names[:req.Limit]
+		result.LastEvaluatedTableName = names[req.Limit-1]
+	}
+	return result, nil
 }
 
 func (db *DB) DeleteTable(req *DeleteTableRequest) (*DeleteTableResult, error) {
-	if _, found := db.Tables[req.TableName]; !found {
-		return nil, fmt.Errorf("", db.Tables, req.TableName)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	table, found := db.Tables[req.TableName]
+	if !found {
+		return nil, tableNotFound(req.TableName)
 	}
-	tableDesc := db.Tables[req.TableName].TableDescription
+	tableDesc := table.description()
+	tableDesc.TableStatus = DeletingTableStatus
 	delete(db.Tables, req.TableName)
 	return &DeleteTableResult{tableDesc}, nil
 }
+
+func tableNotFound(tableName string) error {
+	return &ResourceNotFoundException{Message: fmt.Sprintf("Requested resource not found: Table: %s not found", tableName)}
+}
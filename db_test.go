@@ -1,19 +1,23 @@
 package dynamockdb
 
 import (
+	"errors"
 	"testing"
 )
 
 func TestCreateTable(t *testing.T) {
 	db := NewDB()
 	req := &CreateTableRequest{
-		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "foo", AttributeType: StringAttributeType}},
+		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "foo", AttributeType: StringAttributeType}, AttributeDefinition{AttributeName: "id", AttributeType: StringAttributeType}},
 		KeySchema:             []KeySchemaElement{KeySchemaElement{AttributeName: "id", KeyType: HashKeyType}},
 		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
 		TableName:             "bar",
 		// LocalSecondaryIndexes: []LocalSecondaryIndex{LocalSecondaryIndex{IndexName:"fooIndex", KeySchema: KeySchemaElement{AttributeName: "foo"}, Projection: Projection{}  }}
 	}
-	tableDesc := db.CreateTable(req)
+	tableDesc, err := db.CreateTable(req)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if tableDesc.TableDescription.AttributeDefinitions[0].AttributeName != "foo" {
 		t.Fail()
@@ -23,15 +27,42 @@ func TestCreateTable(t *testing.T) {
 	if db.Tables["bar"] == nil {
 		t.Fail()
 	}
+
+	_, err = db.CreateTable(req)
+	var inUse *ResourceInUseException
+	if !errors.As(err, &inUse) {
+		t.Fatalf("expected ResourceInUseException, got %v", err)
+	}
+
+	req = &CreateTableRequest{
+		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "foo", AttributeType: StringAttributeType}},
+		KeySchema:             []KeySchemaElement{KeySchemaElement{AttributeName: "id", KeyType: HashKeyType}},
+		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
+		TableName:             "baz",
+	}
+	_, err = db.CreateTable(req)
+	var invalid *ValidationException
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected ValidationException for an undefined key attribute, got %v", err)
+	}
 }
 
 func TestDescribeTable(t *testing.T) {
 	db := NewDB()
 	CreateTable(db, "bar")
-	result := db.DescribeTable(&DescribeTableRequest{"bar"})
+	result, err := db.DescribeTable(&DescribeTableRequest{"bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if result.Table.AttributeDefinitions[0].AttributeName != "foo" {
 		t.Fail()
 	}
+
+	_, err = db.DescribeTable(&DescribeTableRequest{"nope"})
+	var notFound *ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ResourceNotFoundException, got %v", err)
+	}
 }
 
 func TestListTables(t *testing.T) {
@@ -42,12 +73,12 @@ func TestListTables(t *testing.T) {
 	CreateTable(db, "bor")
 	CreateTable(db, "bez")
 
-	result := db.ListTables(&ListTablesRequest{})
+	result, _ := db.ListTables(&ListTablesRequest{})
 
 	ExpectTableNames(t, []string{"bar", "baz", "boz", "bor", "bez"}, result.TableNames)
 
 	expectedCount, actualCount := 2, 0
-	resultA := db.ListTables(&ListTablesRequest{Limit: expectedCount})
+	resultA, _ := db.ListTables(&ListTablesRequest{Limit: expectedCount})
 	expectedB := make([]string, 0, 3)
 	for _, tableName := range []string{"bar", "baz", "boz", "bor", "bez"} {
 		found := false
@@ -68,9 +99,16 @@ func TestListTables(t *testing.T) {
 		t.Fatalf("Expected %d Tables returned, got %d", expectedCount, actualCount)
 	}
 
-	resultB := db.ListTables(&ListTablesRequest{ExclusiveStartTableName: resultA.LastEvaluatedTableName})
+	resultB, _ := db.ListTables(&ListTablesRequest{ExclusiveStartTableName: resultA.LastEvaluatedTableName})
 
 	ExpectTableNames(t, expectedB, resultB.TableNames)
+	if resultB.LastEvaluatedTableName != "" {
+		t.Fatalf("last page should have no LastEvaluatedTableName, got %s", resultB.LastEvaluatedTableName)
+	}
+
+	if _, err := db.ListTables(&ListTablesRequest{Limit: 101}); err == nil {
+		t.Fatalf("a limit over 100 should be rejected")
+	}
 }
 
 func TestDeleteTable(t *testing.T) {
@@ -89,9 +127,15 @@ func TestDeleteTable(t *testing.T) {
 		t.Fatalf("", tableDesc.TableDescription.TableName)
 	}
 
-	result := db.ListTables(&ListTablesRequest{})
+	result, _ := db.ListTables(&ListTablesRequest{})
 	ExpectTableNames(t, []string{"bar", "boz", "bor", "bez"}, result.TableNames)
 	DontExpectTableName(t, result.TableNames, "baz")
+
+	_, err = db.DeleteTable(&DeleteTableRequest{"baz"})
+	var notFound *ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ResourceNotFoundException, got %v", err)
+	}
 }
 
 //
@@ -105,7 +149,8 @@ func CreateTable(db *DB, tableName string) *CreateTableResult {
 		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
 		TableName:             tableName,
 	}
-	return db.CreateTable(req)
+	result, _ := db.CreateTable(req)
+	return result
 }
 
 func ExpectTableNames(t *testing.T, expectedTableNames, otherTableNames []string) {
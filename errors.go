@@ -0,0 +1,166 @@
+package dynamockdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIError is implemented by all the errors returned by DB and Table
+// methods. There is one type per DynamoDB error type so callers can match
+// them with errors.As:
+//
+//	var notFound *ResourceNotFoundException
+//	if errors.As(err, &notFound) {
+//		...
+//	}
+type APIError interface {
+	error
+	// ErrorType returns the DynamoDB name of the error.
+	ErrorType() string
+}
+
+// ValidationException is returned when a request is malformed or does not
+// meet DynamoDB constraints.
+type ValidationException struct {
+	Message string
+}
+
+func (e *ValidationException) Error() string     { return errorString(e, e.Message) }
+func (e *ValidationException) ErrorType() string { return "ValidationException" }
+
+// SerializationException is returned when a request can't be decoded.
+type SerializationException struct {
+	Message string
+}
+
+func (e *SerializationException) Error() string     { return errorString(e, e.Message) }
+func (e *SerializationException) ErrorType() string { return "SerializationException" }
+
+// UnknownOperationException is returned for requests to operations that
+// don't exist.
+type UnknownOperationException struct {
+	Message string
+}
+
+func (e *UnknownOperationException) Error() string     { return errorString(e, e.Message) }
+func (e *UnknownOperationException) ErrorType() string { return "UnknownOperationException" }
+
+// ResourceNotFoundException is returned when the table a request is for
+// does not exist.
+type ResourceNotFoundException struct {
+	Message string
+}
+
+func (e *ResourceNotFoundException) Error() string     { return errorString(e, e.Message) }
+func (e *ResourceNotFoundException) ErrorType() string { return "ResourceNotFoundException" }
+
+// ResourceInUseException is returned when creating a table that already
+// exists.
+type ResourceInUseException struct {
+	Message string
+}
+
+func (e *ResourceInUseException) Error() string     { return errorString(e, e.Message) }
+func (e *ResourceInUseException) ErrorType() string { return "ResourceInUseException" }
+
+// ConditionalCheckFailedException is returned when the expectations of a
+// write are not met.
+type ConditionalCheckFailedException struct {
+	Message string
+}
+
+func (e *ConditionalCheckFailedException) Error() string { return errorString(e, e.Message) }
+func (e *ConditionalCheckFailedException) ErrorType() string {
+	return "ConditionalCheckFailedException"
+}
+
+// ProvisionedThroughputExceededException is returned when a table is
+// throttled.
+type ProvisionedThroughputExceededException struct {
+	Message string
+}
+
+func (e *ProvisionedThroughputExceededException) Error() string { return errorString(e, e.Message) }
+func (e *ProvisionedThroughputExceededException) ErrorType() string {
+	return "ProvisionedThroughputExceededException"
+}
+
+// LimitExceededException is returned when an account limit is hit, for
+// instance when the throughput of a table is decreased too many times a day.
+type LimitExceededException struct {
+	Message string
+}
+
+func (e *LimitExceededException) Error() string     { return errorString(e, e.Message) }
+func (e *LimitExceededException) ErrorType() string { return "LimitExceededException" }
+
+// TransactionCanceledException is returned when a transaction is not
+// applied. CancellationReasons has the reason for each of its actions, in
+// order.
+type TransactionCanceledException struct {
+	Message             string
+	CancellationReasons []CancellationReason
+}
+
+func (e *TransactionCanceledException) Error() string { return errorString(e, e.Message) }
+func (e *TransactionCanceledException) ErrorType() string {
+	return "TransactionCanceledException"
+}
+
+// TransactionConflictException is returned when an item is written while a
+// transaction holds it.
+type TransactionConflictException struct {
+	Message string
+}
+
+func (e *TransactionConflictException) Error() string { return errorString(e, e.Message) }
+func (e *TransactionConflictException) ErrorType() string {
+	return "TransactionConflictException"
+}
+
+// TransactionInProgressException is returned when a transaction is
+// submitted again with the same client token while it is still running.
+type TransactionInProgressException struct {
+	Message string
+}
+
+func (e *TransactionInProgressException) Error() string { return errorString(e, e.Message) }
+func (e *TransactionInProgressException) ErrorType() string {
+	return "TransactionInProgressException"
+}
+
+// IdempotentParameterMismatchException is returned when a client token is
+// reused for a different transaction.
+type IdempotentParameterMismatchException struct {
+	Message string
+}
+
+func (e *IdempotentParameterMismatchException) Error() string { return errorString(e, e.Message) }
+func (e *IdempotentParameterMismatchException) ErrorType() string {
+	return "IdempotentParameterMismatchException"
+}
+
+// InternalServerError is returned when something unexpected went wrong. It
+// is served with a 500 status code, all other errors use 400.
+type InternalServerError struct {
+	Message string
+}
+
+func (e *InternalServerError) Error() string     { return errorString(e, e.Message) }
+func (e *InternalServerError) ErrorType() string { return "InternalServerError" }
+
+func errorString(e APIError, message string) string {
+	return e.ErrorType() + ": " + message
+}
+
+// errorMessage returns the message of err without its type.
+func errorMessage(err error) string {
+	if apiErr, ok := err.(APIError); ok {
+		return strings.TrimPrefix(err.Error(), apiErr.ErrorType()+": ")
+	}
+	return err.Error()
+}
+
+func validationErrorf(format string, args ...interface{}) error {
+	return &ValidationException{Message: fmt.Sprintf(format, args...)}
+}
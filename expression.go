@@ -0,0 +1,1234 @@
+package dynamockdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Expressions refer to attributes by name, or through #placeholders
+// defined in ExpressionAttributeNames, and to values through :placeholders
+// defined in ExpressionAttributeValues.
+
+// expressionAttributes holds the placeholders available to the expressions
+// of a request, and records which of them are used.
+type expressionAttributes struct {
+	names  map[string]string
+	values map[string]AttributeValue
+	used   map[string]bool // nil when usage is not checked
+}
+
+func newExpressionAttributes(names map[string]string, values map[string]AttributeValue) expressionAttributes {
+	return expressionAttributes{names: names, values: values, used: make(map[string]bool)}
+}
+
+func (a expressionAttributes) use(placeholder string) {
+	if a.used != nil {
+		a.used[placeholder] = true
+	}
+}
+
+// checkUnused fails if some placeholders are not used by the expressions
+// parsed with a.
+func (a expressionAttributes) checkUnused() error {
+	if unused := unusedPlaceholders(a.names, a.used); unused != nil {
+		return validationErrorf("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	values := make(map[string]string, len(a.values))
+	for k := range a.values {
+		values[k] = ""
+	}
+	if unused := unusedPlaceholders(values, a.used); unused != nil {
+		return validationErrorf("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+func unusedPlaceholders(placeholders map[string]string, used map[string]bool) []string {
+	var unused []string
+	for k := range placeholders {
+		if !used[k] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenNameRef  // #name
+	tokenValueRef // :value
+	tokenNumber   // List index
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var expressionSymbols = []string{"<>", "<=", ">=", "=", "<", ">", "(", ")", "[", "]", ",", ".", "+", "-"}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := make([]token, 0, 16)
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(expression) && expression[i] >= '0' && expression[i] <= '9' {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, expression[start:i], start})
+			continue
+		case c == '#' || c == ':' || isNameChar(c, true):
+			start := i
+			if !isNameChar(c, true) {
+				i++
+			}
+			for i < len(expression) && isNameChar(expression[i], false) {
+				i++
+			}
+			kind := tokenName
+			if c == '#' {
+				kind = tokenNameRef
+			} else if c == ':' {
+				kind = tokenValueRef
+			}
+			if i-start == 1 && kind != tokenName {
+				return nil, fmt.Errorf("Syntax error; token: \"%c\", near: \"%s\"", c, near(expression, start))
+			}
+			tokens = append(tokens, token{kind, expression[start:i], start})
+			continue
+		}
+
+		matched := false
+		for _, symbol := range expressionSymbols {
+			if strings.HasPrefix(expression[i:], symbol) {
+				tokens = append(tokens, token{tokenSymbol, symbol, i})
+				i += len(symbol)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("Invalid character encountered; character: \"%c\", near: \"%s\"", c, near(expression, i))
+		}
+	}
+	return append(tokens, token{tokenEOF, "<EOF>", len(expression)}), nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || !first && c >= '0' && c <= '9'
+}
+
+// near returns the part of expression around pos, for error messages.
+func near(expression string, pos int) string {
+	end := pos + 10
+	if end > len(expression) {
+		end = len(expression)
+	}
+	return expression[pos:end]
+}
+
+// expressionParser is a recursive descent parser for condition and update
+// expressions.
+type expressionParser struct {
+	kind       string // Parameter being parsed, as in "ConditionExpression"
+	expression string
+	tokens     []token
+	pos        int
+	attrs      expressionAttributes
+	names      []string // Top level attributes of the parsed paths
+}
+
+func newExpressionParser(kind, expression string, attrs expressionAttributes) (*expressionParser, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, validationErrorf("Invalid %s: The expression can not be empty;", kind)
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, validationErrorf("Invalid %s: %s", kind, errorMessage(err))
+	}
+	return &expressionParser{kind: kind, expression: expression, tokens: tokens, attrs: attrs}, nil
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword tells if the next token is the keyword word, keywords are case
+// insensitive.
+func (p *expressionParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenName && strings.EqualFold(t.text, word)
+}
+
+func (p *expressionParser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == tokenSymbol && t.text == symbol
+}
+
+// isFunction tells if the next tokens are a function call.
+func (p *expressionParser) isFunction() bool {
+	return p.peek().kind == tokenName && p.tokens[p.pos+1].text == "("
+}
+
+func (p *expressionParser) expectSymbol(symbol string) error {
+	if !p.isSymbol(symbol) {
+		return p.syntaxError()
+	}
+	p.next()
+	return nil
+}
+
+// syntaxError reports the next token as unexpected. The error shows the
+// expression from the previous token to the unexpected one.
+func (p *expressionParser) syntaxError() error {
+	t := p.peek()
+	start, end := t.pos, t.pos+len(t.text)
+	if p.pos > 0 {
+		start = p.tokens[p.pos-1].pos
+	}
+	if t.kind == tokenEOF {
+		end = len(p.expression)
+	}
+	return validationErrorf("Invalid %s: Syntax error; token: \"%s\", near: \"%s\"", p.kind, t.text, p.expression[start:end])
+}
+
+// parseName parses an attribute name, or a #placeholder.
+func (p *expressionParser) parseName() (string, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenName:
+		if reservedWords[strings.ToUpper(t.text)] {
+			return "", validationErrorf("Invalid %s: Attribute name is a reserved keyword; reserved keyword: %s", p.kind, t.text)
+		}
+		p.next()
+		return t.text, nil
+	case tokenNameRef:
+		p.next()
+		name, ok := p.attrs.names[t.text]
+		if !ok {
+			return "", validationErrorf("Invalid %s: An expression attribute name used in the document path is not defined; attribute name: %s", p.kind, t.text)
+		}
+		p.attrs.use(t.text)
+		return name, nil
+	}
+	return "", p.syntaxError()
+}
+
+// parsePath parses a document path, names separated by dots and list
+// indexes:
+//
+//	path := name ( . name | [ number ] )*
+func (p *expressionParser) parsePath() (documentPath, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	p.names = append(p.names, name)
+	path := documentPath{{name: name, index: -1}}
+	for {
+		switch {
+		case p.isSymbol("."):
+			p.next()
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{name: name, index: -1})
+		case p.isSymbol("["):
+			p.next()
+			t := p.peek()
+			index, err := strconv.Atoi(t.text)
+			if t.kind != tokenNumber || err != nil {
+				return nil, p.syntaxError()
+			}
+			p.next()
+			if err := p.expectSymbol("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{index: index})
+		default:
+			return path, nil
+		}
+	}
+}
+
+// parseOperand parses a path, a :placeholder or a call to size.
+func (p *expressionParser) parseOperand() (operand, error) {
+	t := p.peek()
+	if p.isFunction() {
+		if t.text != "size" {
+			return nil, p.functionError(t.text)
+		}
+		p.next()
+		p.next() // (
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkArguments("size", args, 1); err != nil {
+			return nil, err
+		}
+		return sizeOperand{args[0].(documentPath)}, nil
+	}
+
+	if t.kind != tokenValueRef {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return path, nil
+	}
+
+	p.next()
+	v, ok := p.attrs.values[t.text]
+	if !ok {
+		return nil, validationErrorf("Invalid %s: An expression attribute value used in expression is not defined; attribute value: %s", p.kind, t.text)
+	}
+	p.attrs.use(t.text)
+	v, err := v.Normalize()
+	if err != nil {
+		return nil, validationErrorf("%s", errorMessage(err))
+	}
+	return valueOperand{v}, nil
+}
+
+// parseArguments parses the arguments of a function, after its opening
+// parenthesis.
+func (p *expressionParser) parseArguments() ([]operand, error) {
+	var args []operand
+	for {
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expectSymbol(")")
+}
+
+// checkArguments checks function is given n arguments, the first of them
+// being a document path.
+func (p *expressionParser) checkArguments(function string, args []operand, n int) error {
+	if len(args) != n {
+		return validationErrorf("Invalid %s: Incorrect number of operands for operator or function; operator or function: %s, number of operands: %d", p.kind, function, len(args))
+	}
+	if _, ok := args[0].(documentPath); !ok {
+		return validationErrorf("Invalid %s: Operator or function requires a document path; operator or function: %s", p.kind, function)
+	}
+	return nil
+}
+
+func (p *expressionParser) functionError(function string) error {
+	switch function {
+	case "attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains", "size":
+		return validationErrorf("Invalid %s: The function is not allowed to be used this way in an expression; function: %s", p.kind, function)
+	}
+	return validationErrorf("Invalid %s: Invalid function name; function: %s", p.kind, function)
+}
+
+// operand is what expressions compare and assign: attributes of the item
+// or values.
+type operand interface {
+	// resolve returns the value of the operand for item, or false if it
+	// refers to a missing attribute.
+	resolve(item map[string]AttributeValue) (AttributeValue, bool)
+}
+
+// pathElement is a step of a document path: an attribute or map key, or a
+// list index when index is not negative.
+type pathElement struct {
+	name  string
+	index int
+}
+
+// documentPath designates an attribute or an element nested in it.
+type documentPath []pathElement
+
+func (path documentPath) resolve(item map[string]AttributeValue) (AttributeValue, bool) {
+	v, ok := item[path[0].name]
+	for _, e := range path[1:] {
+		switch {
+		case !ok:
+			return AttributeValue{}, false
+		case e.index < 0:
+			if v.Type() != MapAttributeType {
+				return AttributeValue{}, false
+			}
+			v, ok = v.M[e.name]
+		default:
+			if v.Type() != ListAttributeType || e.index >= len(v.L) {
+				return AttributeValue{}, false
+			}
+			v = v.L[e.index]
+		}
+	}
+	return v, ok
+}
+
+// overlaps tells if path and other designate the same element, or if one
+// is nested in the other.
+func (path documentPath) overlaps(other documentPath) bool {
+	for i := 0; i < len(path) && i < len(other); i++ {
+		if path[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// conflicts tells if path and other can't both be valid, as when one indexes
+// a list where the other reads a map.
+func (path documentPath) conflicts(other documentPath) bool {
+	for i := 0; i < len(path) && i < len(other); i++ {
+		if (path[i].index < 0) != (other[i].index < 0) {
+			return true
+		}
+		if path[i] != other[i] {
+			return false
+		}
+	}
+	return false
+}
+
+// checkPaths fails if some of paths overlap or conflict.
+func (p *expressionParser) checkPaths(paths []documentPath) error {
+	for i, path := range paths {
+		for _, other := range paths[:i] {
+			switch {
+			case path.conflicts(other):
+				return validationErrorf("Invalid %s: Two document paths conflict with each other; must remove or rewrite one of these paths; path one: %s, path two: %s", p.kind, other.elements(), path.elements())
+			case path.overlaps(other):
+				return validationErrorf("Invalid %s: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: %s, path two: %s", p.kind, other.elements(), path.elements())
+			}
+		}
+	}
+	return nil
+}
+
+// elements formats path as a list of elements, for error messages.
+func (path documentPath) elements() string {
+	elements := make([]string, len(path))
+	for i, e := range path {
+		if e.index >= 0 {
+			elements[i] = fmt.Sprintf("[%d]", e.index)
+		} else {
+			elements[i] = e.name
+		}
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (path documentPath) String() string {
+	var b strings.Builder
+	for i, e := range path {
+		switch {
+		case e.index >= 0:
+			fmt.Fprintf(&b, "[%d]", e.index)
+		case i > 0:
+			b.WriteString("." + e.name)
+		default:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+type valueOperand struct {
+	value AttributeValue
+}
+
+func (o valueOperand) resolve(item map[string]AttributeValue) (AttributeValue, bool) {
+	return o.value, true
+}
+
+// sizeOperand is the size of a string, binary, set, list or map.
+type sizeOperand struct {
+	path documentPath
+}
+
+func (o sizeOperand) resolve(item map[string]AttributeValue) (AttributeValue, bool) {
+	v, ok := o.path.resolve(item)
+	if !ok {
+		return AttributeValue{}, false
+	}
+	var size int
+	switch v.Type() {
+	case StringAttributeType:
+		size = utf8.RuneCountInString(v.S)
+	case BinaryAttributeType:
+		size = len(v.B)
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		size = len(v.set())
+	case ListAttributeType:
+		size = len(v.L)
+	case MapAttributeType:
+		size = len(v.M)
+	default:
+		return AttributeValue{}, false
+	}
+	return AttributeValue{N: strconv.Itoa(size)}, true
+}
+
+//
+//  Conditions
+//
+
+// condition is a parsed ConditionExpression.
+type condition interface {
+	eval(item map[string]AttributeValue) bool
+}
+
+// parseCondition parses a ConditionExpression:
+//
+//	condition  := or
+//	or         := and ( OR and )*
+//	and        := not ( AND not )*
+//	not        := NOT not | primary
+//	primary    := ( condition ) | function ( operand ( , operand )* )
+//	            | operand comparator operand
+//	            | operand BETWEEN operand AND operand
+//	            | operand IN ( operand ( , operand )* )
+//	operand    := path | :value | size ( path )
+func parseCondition(expression string, attrs expressionAttributes) (condition, error) {
+	c, _, err := parseConditionExpression("ConditionExpression", expression, attrs)
+	return c, err
+}
+
+// parseConditionExpression parses a condition given as the kind parameter
+// and returns it with the top level attributes it refers to.
+func parseConditionExpression(kind, expression string, attrs expressionAttributes) (condition, []string, error) {
+	p, err := newExpressionParser(kind, expression, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, nil, p.syntaxError()
+	}
+	return c, p.names, nil
+}
+
+// parseWriteCondition parses the condition of a write, given either with
+// the legacy Expected map or as a ConditionExpression. It returns nil if
+// there is no ConditionExpression.
+func parseWriteCondition(expected map[string]ExpectedAttributeValue, expression string, attrs expressionAttributes) (condition, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	if len(expected) > 0 {
+		return nil, validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {Expected} Expression parameters: {ConditionExpression}")
+	}
+	return parseCondition(expression, attrs)
+}
+
+func (p *expressionParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{c}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (condition, error) {
+	if p.isSymbol("(") {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectSymbol(")")
+	}
+
+	if p.isFunction() && p.peek().text != "size" {
+		return p.parseFunction()
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.isKeyword("BETWEEN"):
+		return p.parseBetween(left)
+	case p.isKeyword("IN"):
+		return p.parseIn(left)
+	}
+	t := p.peek()
+	switch t.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+		if t.kind != tokenSymbol {
+			return nil, p.syntaxError()
+		}
+	default:
+		return nil, p.syntaxError()
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{t.text, left, right}, nil
+}
+
+func (p *expressionParser) parseBetween(v operand) (condition, error) {
+	p.next() // BETWEEN
+	low, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("AND") {
+		return nil, p.syntaxError()
+	}
+	p.next()
+	high, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// Bounds known upfront must be in order
+	l, lok := low.(valueOperand)
+	h, hok := high.(valueOperand)
+	if lok && hok {
+		if cmp, ok := compareOperands(l.value, h.value); ok && cmp > 0 {
+			return nil, validationErrorf("Invalid %s: The BETWEEN operator requires upper bound to be greater than or equal to lower bound; lower operand: AttributeValue: {%s:%s}, upper operand: AttributeValue: {%s:%s}",
+				p.kind, l.value.Type(), l.value.Value(l.value.Type()), h.value.Type(), h.value.Value(h.value.Type()))
+		}
+	}
+	return between{v, low, high}, nil
+}
+
+// maxInOperands is the number of values IN can compare to.
+const maxInOperands = 100
+
+func (p *expressionParser) parseIn(v operand) (condition, error) {
+	p.next() // IN
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var list []operand
+	for {
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.next()
+	}
+	if len(list) > maxInOperands {
+		return nil, validationErrorf("Invalid %s: Too many operands for operator or function; operator or function: IN, number of operands: %d", p.kind, len(list))
+	}
+	return in{v, list}, p.expectSymbol(")")
+}
+
+// attributeTypes are the types attribute_type accepts.
+var attributeTypes = []AttributeType{BinaryAttributeType, NullAttributeType, StringSetAttributeType, BooleanAttributeType, ListAttributeType, BinarySetAttributeType, NumberAttributeType, NumberSetAttributeType, StringAttributeType, MapAttributeType}
+
+func (p *expressionParser) parseFunction() (condition, error) {
+	function := p.next().text
+	p.next() // (
+	args, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+
+	switch function {
+	case "attribute_exists", "attribute_not_exists":
+		if err := p.checkArguments(function, args, 1); err != nil {
+			return nil, err
+		}
+		return existsCondition{args[0].(documentPath), function == "attribute_exists"}, nil
+	case "attribute_type":
+		if err := p.checkArguments(function, args, 2); err != nil {
+			return nil, err
+		}
+		v, ok := args[1].(valueOperand)
+		if !ok || v.value.Type() != StringAttributeType {
+			return nil, validationErrorf("Invalid %s: Incorrect operand type for operator or function; operator or function: %s, operand type: %s", p.kind, function, operandType(args[1]))
+		}
+		for _, attributeType := range attributeTypes {
+			if string(attributeType) == v.value.S {
+				return typeCondition{args[0].(documentPath), attributeType}, nil
+			}
+		}
+		names := make([]string, len(attributeTypes))
+		for i, attributeType := range attributeTypes {
+			names[i] = string(attributeType)
+		}
+		return nil, validationErrorf("Invalid %s: Invalid attribute type name found; type: %s, valid types: { %s }", p.kind, v.value.S, strings.Join(names, ","))
+	case "begins_with":
+		if err := p.checkArguments(function, args, 2); err != nil {
+			return nil, err
+		}
+		return beginsWith{args[0], args[1]}, nil
+	case "contains":
+		if err := p.checkArguments(function, args, 2); err != nil {
+			return nil, err
+		}
+		return contains{args[0], args[1]}, nil
+	}
+	return nil, p.functionError(function)
+}
+
+// operandType returns the type of o for error messages.
+func operandType(o operand) string {
+	if v, ok := o.(valueOperand); ok {
+		return string(v.value.Type())
+	}
+	return "PATH"
+}
+
+type andCondition struct {
+	left, right condition
+}
+
+func (c andCondition) eval(item map[string]AttributeValue) bool {
+	return c.left.eval(item) && c.right.eval(item)
+}
+
+type orCondition struct {
+	left, right condition
+}
+
+func (c orCondition) eval(item map[string]AttributeValue) bool {
+	return c.left.eval(item) || c.right.eval(item)
+}
+
+type notCondition struct {
+	c condition
+}
+
+func (c notCondition) eval(item map[string]AttributeValue) bool {
+	return !c.c.eval(item)
+}
+
+type existsCondition struct {
+	path   documentPath
+	exists bool
+}
+
+func (c existsCondition) eval(item map[string]AttributeValue) bool {
+	_, ok := c.path.resolve(item)
+	return ok == c.exists
+}
+
+type typeCondition struct {
+	path          documentPath
+	attributeType AttributeType
+}
+
+func (c typeCondition) eval(item map[string]AttributeValue) bool {
+	v, ok := c.path.resolve(item)
+	return ok && v.Type() == c.attributeType
+}
+
+// comparison compares two operands. Only values of the same type compare,
+// and only scalars can be ordered. A missing attribute is different from
+// everything.
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (c comparison) eval(item map[string]AttributeValue) bool {
+	a, aok := c.left.resolve(item)
+	b, bok := c.right.resolve(item)
+	if c.op == "=" || c.op == "<>" {
+		equal := aok && bok && a.Equal(&b)
+		return equal == (c.op == "=")
+	}
+
+	if !aok || !bok {
+		return false
+	}
+	cmp, ok := compareOperands(a, b)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// compareOperands orders a and b, it returns false if they are not scalars
+// of the same type.
+func compareOperands(a, b AttributeValue) (int, bool) {
+	attributeType := a.Type()
+	if attributeType != b.Type() {
+		return 0, false
+	}
+	switch attributeType {
+	case StringAttributeType, NumberAttributeType, BinaryAttributeType:
+		return compareValues(a, b, attributeType), true
+	}
+	return 0, false
+}
+
+type between struct {
+	v, low, high operand
+}
+
+func (c between) eval(item map[string]AttributeValue) bool {
+	v, ok := c.v.resolve(item)
+	low, lok := c.low.resolve(item)
+	high, hok := c.high.resolve(item)
+	if !ok || !lok || !hok {
+		return false
+	}
+	cmpLow, lok := compareOperands(v, low)
+	cmpHigh, hok := compareOperands(v, high)
+	return lok && hok && cmpLow >= 0 && cmpHigh <= 0
+}
+
+type in struct {
+	v    operand
+	list []operand
+}
+
+func (c in) eval(item map[string]AttributeValue) bool {
+	v, ok := c.v.resolve(item)
+	if !ok {
+		return false
+	}
+	for _, o := range c.list {
+		if other, ok := o.resolve(item); ok && v.Equal(&other) {
+			return true
+		}
+	}
+	return false
+}
+
+// beginsWith tells if a string or a binary starts with another of the same
+// type.
+type beginsWith struct {
+	v, prefix operand
+}
+
+func (c beginsWith) eval(item map[string]AttributeValue) bool {
+	v, ok := c.v.resolve(item)
+	prefix, pok := c.prefix.resolve(item)
+	if !ok || !pok || v.Type() != prefix.Type() {
+		return false
+	}
+	switch v.Type() {
+	case StringAttributeType, BinaryAttributeType:
+		return v.HasPrefix(&prefix, v.Type())
+	}
+	return false
+}
+
+// contains tells if a string holds a substring, a set an element or a list
+// a value.
+type contains struct {
+	v, element operand
+}
+
+func (c contains) eval(item map[string]AttributeValue) bool {
+	v, ok := c.v.resolve(item)
+	element, eok := c.element.resolve(item)
+	if !ok || !eok {
+		return false
+	}
+	switch attributeType := v.Type(); attributeType {
+	case StringAttributeType:
+		return element.Type() == StringAttributeType && strings.Contains(v.S, element.S)
+	case BinaryAttributeType:
+		return element.Type() == BinaryAttributeType && strings.Contains(string(v.B), string(element.B))
+	case StringSetAttributeType, NumberSetAttributeType, BinarySetAttributeType:
+		elementType := elementType(attributeType)
+		return element.Type() == elementType && setContains(v.set(), element.Value(elementType), elementType)
+	case ListAttributeType:
+		for _, e := range v.L {
+			if e.Equal(&element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+//  Key conditions
+//
+
+// parseKeyCondition parses a KeyConditionExpression into the legacy
+// KeyConditions it stands for. It is a condition on the hash key, possibly
+// and-ed with one on the range key, made of comparisons, BETWEEN and
+// begins_with between a key attribute and values.
+func parseKeyCondition(expression string, attrs expressionAttributes) (map[string]Condition, error) {
+	c, _, err := parseConditionExpression("KeyConditionExpression", expression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	conditions := make(map[string]Condition)
+	if err := addKeyConditions(conditions, c); err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+var keyComparisonOperators = map[string]ConditionOperator{"=": EQ, "<": LT, "<=": LE, ">": GT, ">=": GE}
+
+func addKeyConditions(conditions map[string]Condition, c condition) error {
+	var operator ConditionOperator
+	var operands []operand // The key attribute then the values
+	switch c := c.(type) {
+	case andCondition:
+		if err := addKeyConditions(conditions, c.left); err != nil {
+			return err
+		}
+		return addKeyConditions(conditions, c.right)
+	case comparison:
+		var ok bool
+		if operator, ok = keyComparisonOperators[c.op]; !ok {
+			return invalidKeyOperator(c.op)
+		}
+		operands = []operand{c.left, c.right}
+	case between:
+		operator, operands = BETWEEN, []operand{c.v, c.low, c.high}
+	case beginsWith:
+		operator, operands = BEGINS_WITH, []operand{c.v, c.prefix}
+	case orCondition:
+		return invalidKeyOperator("OR")
+	case notCondition:
+		return invalidKeyOperator("NOT")
+	case in:
+		return invalidKeyOperator("IN")
+	case existsCondition:
+		if c.exists {
+			return invalidKeyOperator("attribute_exists")
+		}
+		return invalidKeyOperator("attribute_not_exists")
+	case typeCondition:
+		return invalidKeyOperator("attribute_type")
+	default:
+		return invalidKeyOperator("contains")
+	}
+
+	for i, o := range operands {
+		if _, ok := o.(sizeOperand); ok {
+			return invalidKeyOperator("size")
+		}
+		_, isPath := o.(documentPath)
+		_, isValue := o.(valueOperand)
+		if i == 0 && !isPath || i > 0 && !isValue {
+			return validationErrorf("Invalid KeyConditionExpression: A key condition must compare a key attribute to values")
+		}
+	}
+	path := operands[0].(documentPath)
+	if len(path) > 1 {
+		return validationErrorf("KeyConditionExpressions cannot have conditions on nested attributes")
+	}
+	name := path[0].name
+	if _, ok := conditions[name]; ok {
+		return validationErrorf("KeyConditionExpressions must only contain one condition per key")
+	}
+	cond := Condition{ConditionOperator: operator}
+	for _, o := range operands[1:] {
+		cond.AttributeValueList = append(cond.AttributeValueList, o.(valueOperand).value)
+	}
+	conditions[name] = cond
+	return nil
+}
+
+func invalidKeyOperator(operator string) error {
+	return validationErrorf("Invalid operator used in KeyConditionExpression: %s", operator)
+}
+
+//
+//  Legacy filters
+//
+
+// parseLegacyFilter turns the conditions of a QueryFilter or a ScanFilter,
+// named param, into the condition they stand for. The conditions are and-ed
+// or or-ed together as set by conditionalOperator.
+func parseLegacyFilter(param string, filter map[string]Condition, conditionalOperator ConditionalOperator) (condition, error) {
+	switch conditionalOperator {
+	case "", AndConditionalOperator, OrConditionalOperator:
+	default:
+		return nil, validationErrorf("1 validation error detected: Value '%s' at 'conditionalOperator' failed to satisfy constraint: Member must satisfy enum value set: [OR, AND]", conditionalOperator)
+	}
+
+	names := make([]string, 0, len(filter))
+	for name := range filter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var c condition
+	for _, name := range names {
+		next, err := legacyCondition(param, name, filter[name])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c == nil:
+			c = next
+		case conditionalOperator == OrConditionalOperator:
+			c = orCondition{c, next}
+		default:
+			c = andCondition{c, next}
+		}
+	}
+	return c, nil
+}
+
+// legacyCondition returns the condition cond puts on the attribute name.
+func legacyCondition(param, name string, cond Condition) (condition, error) {
+	path := documentPath{{name: name, index: -1}}
+	operator := cond.ConditionOperator
+	values := make([]operand, len(cond.AttributeValueList))
+	for i, v := range cond.AttributeValueList {
+		v, err := v.Normalize()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = valueOperand{v}
+	}
+
+	arguments := 1
+	scalar := true // Operators that only take scalar values
+	switch operator {
+	case EQ, NE:
+		scalar = false
+	case NULL, NOT_NULL:
+		arguments = 0
+	case BETWEEN:
+		arguments = 2
+	case IN:
+		arguments = -1 // At least one
+	case LE, LT, GE, GT, CONTAINS, NOT_CONTAINS, BEGINS_WITH:
+	default:
+		return nil, validationErrorf("1 validation error detected: Value '%s' at '%s.%s.member.comparisonOperator' failed to satisfy constraint: Member must satisfy enum value set: [IN, NULL, BETWEEN, LT, NOT_CONTAINS, EQ, GT, NOT_NULL, NE, LE, BEGINS_WITH, GE, CONTAINS]", operator, strings.ToLower(param[:1])+param[1:], name)
+	}
+	if arguments >= 0 && len(values) != arguments || arguments < 0 && len(values) == 0 {
+		return nil, validationErrorf("One or more parameter values were invalid: Invalid number of argument(s) for the %s ComparisonOperator", operator)
+	}
+	for _, v := range cond.AttributeValueList {
+		switch attributeType := v.Type(); {
+		case scalar && attributeType != StringAttributeType && attributeType != NumberAttributeType && attributeType != BinaryAttributeType,
+			operator == BEGINS_WITH && attributeType == NumberAttributeType:
+			return nil, validationErrorf("One or more parameter values were invalid: ComparisonOperator %s is not valid for %s AttributeValue type", operator, attributeType)
+		}
+	}
+
+	switch operator {
+	case EQ:
+		return comparison{"=", path, values[0]}, nil
+	case NE:
+		return comparison{"<>", path, values[0]}, nil
+	case LE:
+		return comparison{"<=", path, values[0]}, nil
+	case LT:
+		return comparison{"<", path, values[0]}, nil
+	case GE:
+		return comparison{">=", path, values[0]}, nil
+	case GT:
+		return comparison{">", path, values[0]}, nil
+	case NULL:
+		return existsCondition{path, false}, nil
+	case NOT_NULL:
+		return existsCondition{path, true}, nil
+	case CONTAINS:
+		return contains{path, values[0]}, nil
+	case NOT_CONTAINS:
+		return notCondition{contains{path, values[0]}}, nil
+	case BEGINS_WITH:
+		return beginsWith{path, values[0]}, nil
+	case IN:
+		return in{path, values}, nil
+	}
+	return between{path, values[0], values[1]}, nil
+}
+
+//
+//  Projections
+//
+
+// parseProjection parses a ProjectionExpression into the paths to return:
+//
+//	projection := path ( , path )*
+func parseProjection(expression string, attrs expressionAttributes) ([]documentPath, error) {
+	p, err := newExpressionParser("ProjectionExpression", expression, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []documentPath
+	for {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.next()
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.syntaxError()
+	}
+	if err := p.checkPaths(paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// parseReadProjection returns the paths a read returns, given either with
+// the legacy AttributesToGet or as a ProjectionExpression. It returns nil
+// if the whole item is returned.
+func parseReadProjection(attributesToGet []string, expression string, attrs expressionAttributes) ([]documentPath, error) {
+	if expression == "" {
+		var paths []documentPath
+		for _, name := range attributesToGet {
+			paths = append(paths, documentPath{{name: name, index: -1}})
+		}
+		return paths, nil
+	}
+	if len(attributesToGet) > 0 {
+		return nil, validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {AttributesToGet} Expression parameters: {ProjectionExpression}")
+	}
+	return parseProjection(expression, attrs)
+}
+
+// projectPaths returns the parts of item at paths, nested as they are in
+// item. Elements selected from a list keep their order.
+func projectPaths(item map[string]AttributeValue, paths []documentPath) map[string]AttributeValue {
+	root := &projectionNode{}
+	for _, path := range paths {
+		v, ok := path.resolve(item)
+		if !ok {
+			continue
+		}
+		node := root
+		for _, e := range path {
+			node = node.child(e)
+		}
+		node.value = &v
+	}
+	return root.attributeValue().M
+}
+
+// projectionNode is a part of a projected item, either a value or the
+// selected elements of a map or a list.
+type projectionNode struct {
+	value    *AttributeValue
+	fields   map[string]*projectionNode
+	elements map[int]*projectionNode
+}
+
+func (n *projectionNode) child(e pathElement) *projectionNode {
+	if e.index >= 0 {
+		if n.elements == nil {
+			n.elements = make(map[int]*projectionNode)
+		}
+		if n.elements[e.index] == nil {
+			n.elements[e.index] = &projectionNode{}
+		}
+		return n.elements[e.index]
+	}
+	if n.fields == nil {
+		n.fields = make(map[string]*projectionNode)
+	}
+	if n.fields[e.name] == nil {
+		n.fields[e.name] = &projectionNode{}
+	}
+	return n.fields[e.name]
+}
+
+func (n *projectionNode) attributeValue() AttributeValue {
+	switch {
+	case n.value != nil:
+		return *n.value
+	case n.elements != nil:
+		indexes := make([]int, 0, len(n.elements))
+		for i := range n.elements {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		l := make([]AttributeValue, len(indexes))
+		for i, index := range indexes {
+			l[i] = n.elements[index].attributeValue()
+		}
+		return AttributeValue{L: l}
+	}
+	m := make(map[string]AttributeValue, len(n.fields))
+	for name, field := range n.fields {
+		m[name] = field.attributeValue()
+	}
+	return AttributeValue{M: m}
+}
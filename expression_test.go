@@ -0,0 +1,116 @@
+package dynamockdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	item := map[string]AttributeValue{
+		"id":   AttributeValue{S: "bar"},
+		"hits": AttributeValue{N: "3"},
+		"size": AttributeValue{N: "10"},
+		"tags": AttributeValue{SS: []string{"a", "b"}},
+		"doc": AttributeValue{M: map[string]AttributeValue{
+			"entries": AttributeValue{L: []AttributeValue{AttributeValue{S: "first"}, AttributeValue{N: "2"}}},
+		}},
+	}
+	attrs := expressionAttributes{
+		names: map[string]string{"#s": "size", "#d": "doc"},
+		values: map[string]AttributeValue{
+			":two": AttributeValue{N: "2.0"}, ":five": AttributeValue{N: "5"}, ":bar": AttributeValue{S: "bar"},
+			":b": AttributeValue{S: "b"}, ":ba": AttributeValue{S: "ba"}, ":fir": AttributeValue{S: "fir"},
+			":ss": AttributeValue{S: "SS"}, ":n": AttributeValue{S: "N"},
+		},
+	}
+
+	conditions := map[string]bool{
+		"id = :bar":                                 true,
+		"id <> :bar":                                false,
+		"absent <> :bar":                            true,
+		"hits > :two AND #s >= hits":                true,
+		"hits < :two OR attribute_exists(id)":       true,
+		"NOT (hits < :two OR attribute_exists(id))": false,
+		"attribute_not_exists(absent) and id = id":  true,
+		"id > :two":                                 false,
+	}
+	for expression, expected := range conditions {
+		c, err := parseCondition(expression, attrs)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		if c.eval(item) != expected {
+			t.Fatalf("%s should be %v", expression, expected)
+		}
+	}
+
+	invalid := map[string]string{
+		"":                          "The expression can not be empty",
+		"id = ":                     "Syntax error; token: \"<EOF>\"",
+		"id = :nope":                ":nope",
+		"#nope = :bar":              "#nope",
+		"id = :bar )":               "Syntax error; token: \")\"",
+		"foo(id)":                   "Invalid function name",
+		"id = :bar ; x":             "Invalid character",
+		"id BETWEEN :five AND :two": "upper bound to be greater than or equal to lower bound",
+		"id IN :bar":                "Syntax error; token: \":bar\", near: \"IN :bar\"",
+		"begins_with(id)":           "Incorrect number of operands for operator or function; operator or function: begins_with, number of operands: 1",
+		"attribute_exists(:bar)":    "Operator or function requires a document path",
+		"attribute_type(id, :bar)":  "Invalid attribute type name found; type: bar",
+		"attribute_type(id, :two)":  "Incorrect operand type",
+		"size(id)":                  "Syntax error; token: \"<EOF>\", near: \")\"",
+		"id = attribute_exists(id)": "The function is not allowed to be used this way",
+		"id[x] = :bar":              "Syntax error; token: \"x\", near: \"[x\"",
+		"id = :bar AND":             "Syntax error; token: \"<EOF>\", near: \"AND\"",
+	}
+	for expression, message := range invalid {
+		_, err := parseCondition(expression, attrs)
+		if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") || !strings.Contains(err.Error(), message) {
+			t.Fatalf("%s: expected ValidationException with %q, got %v", expression, message, err)
+		}
+	}
+}
+
+func TestExpressionAttributesUnused(t *testing.T) {
+	attrs := newExpressionAttributes(map[string]string{"#a": "a", "#b": "b"}, map[string]AttributeValue{":v": AttributeValue{S: "v"}})
+	if _, err := parseCondition("#a = :v", attrs); err != nil {
+		t.Fatal(err)
+	}
+	err := attrs.checkUnused()
+	if err == nil || !strings.HasSuffix(err.Error(), "Value provided in ExpressionAttributeNames unused in expressions: keys: {#b}") {
+		t.Fatalf("expected ValidationException for #b, got %v", err)
+	}
+	if _, err := parseCondition("#b = :v", attrs); err != nil {
+		t.Fatal(err)
+	}
+	if err := attrs.checkUnused(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	item := map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "a": AttributeValue{S: "x"}, "b": AttributeValue{S: "y"}}
+	attrs := expressionAttributes{values: map[string]AttributeValue{":z": AttributeValue{S: "z"}}}
+
+	actions, err := parseUpdate("SET a = b, b = a, c = :z REMOVE id", attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := applyUpdate(actions, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 3 || updated["a"].S != "y" || updated["b"].S != "x" || updated["c"].S != "z" {
+		t.Fatalf("wrong update %+v", updated)
+	}
+	if item["a"].S != "x" {
+		t.Fatal("the original item should not be modified")
+	}
+
+	if _, err := parseUpdate("SET a = :z SET b = :z", attrs); err == nil || !strings.Contains(err.Error(), "can only be used once") {
+		t.Fatalf("expected ValidationException for a repeated clause, got %v", err)
+	}
+	if _, err := parseUpdate("ADD a :z", attrs); err == nil {
+		t.Fatal("expected ValidationException for an unsupported clause")
+	}
+}
@@ -0,0 +1,240 @@
+package dynamockdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	jsonContentType = "application/x-amz-json-1.0"
+	errorTypePrefix = "com.amazonaws.dynamodb.v20120810#"
+)
+
+// Handler serves the DynamoDB JSON 1.0 API over HTTP. Operations are
+// selected with the X-Amz-Target header, as in
+// "DynamoDB_20120810.PutItem", and their request is the JSON body.
+type Handler struct {
+	DB *DB
+}
+
+func NewHandler(db *DB) *Handler {
+	return &Handler{DB: db}
+}
+
+// operation decodes a request with dec, runs it against db and returns the
+// result to send back.
+type operation func(db *DB, dec *json.Decoder) (interface{}, error)
+
+var operations = map[string]operation{
+	"CreateTable": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &CreateTableRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.CreateTable(req)
+	},
+	"DeleteTable": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &DeleteTableRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.DeleteTable(req)
+	},
+	"DescribeTable": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &DescribeTableRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.DescribeTable(req)
+	},
+	"ListTables": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &ListTablesRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.ListTables(req)
+	},
+	"UpdateTable": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &UpdateTableRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.UpdateTable(req)
+	},
+	"PutItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &PutItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.PutItem(req)
+	},
+	"GetItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &GetItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.GetItem(req)
+	},
+	"UpdateItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &UpdateItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.UpdateItem(req)
+	},
+	"DeleteItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &DeleteItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.DeleteItem(req)
+	},
+	"Query": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &QueryRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.Query(req)
+	},
+	"BatchGetItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &BatchGetItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.BatchGetItem(req)
+	},
+	"BatchWriteItem": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &BatchWriteItemRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.BatchWriteItem(req)
+	},
+	"TransactGetItems": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &TransactGetItemsRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.TransactGetItems(req)
+	},
+	"TransactWriteItems": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &TransactWriteItemsRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		return db.TransactWriteItems(req)
+	},
+	"Scan": func(db *DB, dec *json.Decoder) (interface{}, error) {
+		req := &ScanRequest{}
+		if err := decodeRequest(dec, req); err != nil {
+			return nil, err
+		}
+		table, err := db.table(req.TableName)
+		if err != nil {
+			return nil, err
+		}
+		return table.Scan(req)
+	},
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("dynamockdb: panic serving %s: %v", r.Header.Get("X-Amz-Target"), err)
+			writeError(w, &InternalServerError{Message: fmt.Sprint(err)})
+		}
+	}()
+
+	target := r.Header.Get("X-Amz-Target")
+	if target == "" {
+		writeError(w, &UnknownOperationException{Message: "Missing X-Amz-Target header"})
+		return
+	}
+
+	t := strings.Split(target, ".")
+	op, ok := operations[t[len(t)-1]]
+	if !ok {
+		writeError(w, &UnknownOperationException{Message: fmt.Sprintf("Unknown operation %s", target)})
+		return
+	}
+
+	result, err := op(h.DB, json.NewDecoder(r.Body))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("dynamockdb: error encoding %s result: %s", target, err)
+	}
+}
+
+// decodeRequest decodes the JSON request read by dec in req. An empty body
+// is an empty request.
+func decodeRequest(dec *json.Decoder, req interface{}) error {
+	err := dec.Decode(req)
+	if err == nil || err == io.EOF {
+		return nil
+	}
+	// Invalid attribute values are reported as they are
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return &SerializationException{Message: err.Error()}
+}
+
+// writeError sends err to the client the way DynamoDB reports errors. Errors
+// that are not an APIError are reported as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = &InternalServerError{Message: err.Error()}
+	}
+
+	status := http.StatusBadRequest
+	if _, ok := apiErr.(*InternalServerError); ok {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	body := map[string]interface{}{
+		"__type":  errorTypePrefix + apiErr.ErrorType(),
+		"message": errorMessage(apiErr),
+	}
+	if canceled, ok := apiErr.(*TransactionCanceledException); ok {
+		body["CancellationReasons"] = canceled.CancellationReasons
+	}
+	json.NewEncoder(w).Encode(body)
+}
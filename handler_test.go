@@ -0,0 +1,121 @@
+package dynamockdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(NewHandler(NewDB()))
+	defer server.Close()
+
+	// Create a table
+
+	var createResult map[string]interface{}
+	status := CallHandler(t, server, "CreateTable", `{
+		"TableName": "bar",
+		"AttributeDefinitions": [{"AttributeName": "id", "AttributeType": "S"}, {"AttributeName": "date", "AttributeType": "N"}],
+		"KeySchema": [{"AttributeName": "id", "KeyType": "HASH"}, {"AttributeName": "date", "KeyType": "RANGE"}],
+		"ProvisionedThroughput": {"ReadCapacityUnits": 5, "WriteCapacityUnits": 5}
+	}`, &createResult)
+	if status != http.StatusOK {
+		t.Fatalf("CreateTable failed: %d %+v", status, createResult)
+	}
+
+	// Items
+
+	status = CallHandler(t, server, "PutItem", `{"TableName": "bar", "Item": {"id": {"S": "foo"}, "date": {"N": "1"}, "v": {"M": {"a": {"BOOL": true}}}}}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("PutItem failed: %d", status)
+	}
+	status = CallHandler(t, server, "PutItem", `{"TableName": "bar", "Item": {"id": {"S": "foo"}, "date": {"N": "2"}}}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("PutItem failed: %d", status)
+	}
+
+	var getResult GetItemResult
+	status = CallHandler(t, server, "GetItem", `{"TableName": "bar", "Key": {"id": {"S": "foo"}, "date": {"N": "1.0"}}}`, &getResult)
+	if status != http.StatusOK || !*getResult.Item["v"].M["a"].BOOL {
+		t.Fatalf("GetItem failed: %d %+v", status, getResult)
+	}
+
+	var queryResult QueryResult
+	status = CallHandler(t, server, "Query", `{"TableName": "bar", "KeyConditions": {"id": {"ComparisonOperator": "EQ", "AttributeValueList": [{"S": "foo"}]}}}`, &queryResult)
+	if status != http.StatusOK || len(queryResult.Items) != 2 || queryResult.Items[1]["date"].N != "2" {
+		t.Fatalf("Query failed: %d %+v", status, queryResult)
+	}
+
+	var scanResult ScanResult
+	status = CallHandler(t, server, "Scan", `{"TableName": "bar", "Limit": 1}`, &scanResult)
+	if status != http.StatusOK || scanResult.Count != 1 || scanResult.LastEvaluatedKey["date"].N != "1" {
+		t.Fatalf("Scan failed: %d %+v", status, scanResult)
+	}
+
+	var listResult ListTablesResult
+	status = CallHandler(t, server, "ListTables", ``, &listResult)
+	if status != http.StatusOK || len(listResult.TableNames) != 1 || listResult.TableNames[0] != "bar" {
+		t.Fatalf("ListTables failed: %d %+v", status, listResult)
+	}
+
+	// Errors
+
+	var errorResult map[string]string
+	status = CallHandler(t, server, "GetItem", `{"TableName": "baz", "Key": {"id": {"S": "foo"}}}`, &errorResult)
+	if status != http.StatusBadRequest || errorResult["__type"] != "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException" {
+		t.Fatalf("expected ResourceNotFoundException, got %d %+v", status, errorResult)
+	}
+
+	status = CallHandler(t, server, "PutItem", `{"TableName": "bar", "Item": {"id": {"S": "foo", "N": "1"}}}`, &errorResult)
+	if status != http.StatusBadRequest || errorResult["__type"] != "com.amazonaws.dynamodb.v20120810#ValidationException" {
+		t.Fatalf("expected ValidationException, got %d %+v", status, errorResult)
+	}
+
+	status = CallHandler(t, server, "PutItem", `{"TableName": `, &errorResult)
+	if status != http.StatusBadRequest || errorResult["__type"] != "com.amazonaws.dynamodb.v20120810#SerializationException" {
+		t.Fatalf("expected SerializationException, got %d %+v", status, errorResult)
+	}
+
+	var canceledResult struct {
+		Type                string `json:"__type"`
+		CancellationReasons []CancellationReason
+	}
+	status = CallHandler(t, server, "TransactWriteItems", `{"TransactItems": [
+		{"ConditionCheck": {"TableName": "bar", "Key": {"id": {"S": "foo"}, "date": {"N": "1"}}, "ConditionExpression": "attribute_not_exists(v)"}}
+	]}`, &canceledResult)
+	if status != http.StatusBadRequest || !strings.HasSuffix(canceledResult.Type, "#TransactionCanceledException") ||
+		len(canceledResult.CancellationReasons) != 1 || canceledResult.CancellationReasons[0].Code != "ConditionalCheckFailed" {
+		t.Fatalf("expected TransactionCanceledException, got %d %+v", status, canceledResult)
+	}
+
+	status = CallHandler(t, server, "FlyToTheMoon", `{}`, &errorResult)
+	if status != http.StatusBadRequest || !strings.HasSuffix(errorResult["__type"], "#UnknownOperationException") {
+		t.Fatalf("expected UnknownOperationException, got %d %+v", status, errorResult)
+	}
+}
+
+// CallHandler sends the operation op with body to server and decodes the
+// response in result. It returns the HTTP status code.
+func CallHandler(t *testing.T, server *httptest.Server, op, body string, result interface{}) int {
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("%s: %s", op, err)
+		}
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,243 @@
+package dynamockdb
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Items of a table are stored in partitions, one per hash key value. The
+// partitions are kept in the order DynamoDB spreads them in, by a hash of
+// the hash key value, and each of them keeps its items ordered by range key.
+// For tables with no range key, partitions hold a single item.
+
+// partitionRef is the key partitions are ordered by.
+type partitionRef struct {
+	hash uint32
+	key  string
+}
+
+func newPartitionRef(key string) partitionRef {
+	sum := md5.Sum([]byte(key))
+	return partitionRef{binary.BigEndian.Uint32(sum[:4]), key}
+}
+
+func comparePartitionRefs(a, b interface{}) int {
+	ra, rb := a.(partitionRef), b.(partitionRef)
+	switch {
+	case ra.hash < rb.hash:
+		return -1
+	case ra.hash > rb.hash:
+		return 1
+	case ra.key < rb.key:
+		return -1
+	case ra.key > rb.key:
+		return 1
+	}
+	return 0
+}
+
+type partition struct {
+	HashKey AttributeValue
+	Items   *skipList // Items ordered by range key
+}
+
+// itemKey is the primary key of an item.
+type itemKey struct {
+	hash     string         // Hash key value, selects the partition
+	hashKey  AttributeValue // Hash key attribute
+	rangeKey AttributeValue // Range key attribute, zero for hash only tables
+}
+
+// keyOf extracts the primary key from attrs which can be a full item or a
+// key.
+func (t *Table) keyOf(attrs map[string]AttributeValue) (itemKey, error) {
+	hashKey := t.HashKey()
+	val, err := keyAttribute(attrs, hashKey)
+	if err != nil {
+		return itemKey{}, err
+	}
+	key := itemKey{hash: val.Value(hashKey.AttributeType), hashKey: val}
+
+	rangeKey := t.RangeKey()
+	if rangeKey == nil {
+		return key, nil
+	}
+	if key.rangeKey, err = keyAttribute(attrs, rangeKey); err != nil {
+		return itemKey{}, err
+	}
+	return key, nil
+}
+
+// parseKey extracts the primary key from attrs which must be a key: the key
+// attributes and nothing else.
+func (t *Table) parseKey(attrs map[string]AttributeValue) (itemKey, error) {
+	key, err := t.keyOf(attrs)
+	if err != nil {
+		return itemKey{}, err
+	}
+	for name := range attrs {
+		if !t.isKeyAttribute(name) {
+			return itemKey{}, validationErrorf("The provided key element does not match the schema")
+		}
+	}
+	return key, nil
+}
+
+// keyAttribute returns the normalized value of the key attribute def in
+// attrs.
+func keyAttribute(attrs map[string]AttributeValue, def *AttributeDefinition) (AttributeValue, error) {
+	val, ok := attrs[def.AttributeName]
+	if ok && val.Type() != "" && val.Type() != def.AttributeType {
+		return AttributeValue{}, validationErrorf("The provided key element does not match the schema")
+	}
+	if !ok || val.Value(def.AttributeType) == "" {
+		return AttributeValue{}, validationErrorf("One of the required keys was not given a value: %s", def.AttributeName)
+	}
+	return val.Normalize()
+}
+
+// attributes returns the key as an item holding only the key attributes.
+func (key itemKey) attributes(t *Table) map[string]AttributeValue {
+	attrs := map[string]AttributeValue{t.HashKey().AttributeName: key.hashKey}
+	if rangeKey := t.RangeKey(); rangeKey != nil {
+		attrs[rangeKey.AttributeName] = key.rangeKey
+	}
+	return attrs
+}
+
+// ItemKey returns a string uniquely identifying the item with the primary
+// key found in attrs. attrs can be a full item or a key.
+func (t *Table) ItemKey(attrs map[string]AttributeValue) (string, error) {
+	key, err := t.keyOf(attrs)
+	if err != nil {
+		return "", err
+	}
+
+	rangeKey := t.RangeKey()
+	if rangeKey == nil {
+		return key.hash, nil
+	}
+
+	// Length prefix the hash part so that no two key pairs share a string
+	return fmt.Sprintf("%d:%s%s", len(key.hash), key.hash, key.rangeKey.Value(rangeKey.AttributeType)), nil
+}
+
+// itemID identifies the item at key across all tables.
+func (t *Table) itemID(key itemKey) string {
+	k, _ := t.ItemKey(key.attributes(t))
+	return t.TableDescription.TableName + "\x00" + k
+}
+
+// compareRangeKeys orders the items of a partition.
+func (t *Table) compareRangeKeys(a, b interface{}) int {
+	rangeKey := t.RangeKey()
+	if rangeKey == nil {
+		return 0
+	}
+	return compareValues(a.(AttributeValue), b.(AttributeValue), rangeKey.AttributeType)
+}
+
+// partition returns the partition for the hash key value or nil.
+func (t *Table) partition(hash string) *partition {
+	p, ok := t.partitions.Get(newPartitionRef(hash))
+	if !ok {
+		return nil
+	}
+	return p.(*partition)
+}
+
+// lookup returns the item stored under key or nil.
+func (t *Table) lookup(key itemKey) map[string]AttributeValue {
+	p := t.partition(key.hash)
+	if p == nil {
+		return nil
+	}
+	item, ok := p.Items.Get(key.rangeKey)
+	if !ok {
+		return nil
+	}
+	return item.(map[string]AttributeValue)
+}
+
+// store inserts or replaces the item stored under key.
+func (t *Table) store(key itemKey, item map[string]AttributeValue) {
+	p := t.partition(key.hash)
+	if p == nil {
+		p = &partition{HashKey: key.hashKey, Items: newSkipList(t.compareRangeKeys)}
+		t.partitions.Set(newPartitionRef(key.hash), p)
+	}
+	if p.Items.Set(key.rangeKey, item) {
+		t.TableDescription.ItemCount++
+	}
+}
+
+// remove deletes the item stored under key and returns it, or nil if there
+// was none.
+func (t *Table) remove(key itemKey) map[string]AttributeValue {
+	p := t.partition(key.hash)
+	if p == nil {
+		return nil
+	}
+	item, ok := p.Items.Delete(key.rangeKey)
+	if !ok {
+		return nil
+	}
+	if p.Items.Len() == 0 {
+		t.partitions.Delete(newPartitionRef(key.hash))
+	}
+	t.TableDescription.ItemCount--
+	return item.(map[string]AttributeValue)
+}
+
+// itemCollectionMetrics estimates the size of the item collection of key,
+// the items sharing its hash key.
+func (t *Table) itemCollectionMetrics(key itemKey) ItemCollectionMetrics {
+	size := 0
+	if p := t.partition(key.hash); p != nil {
+		for n := p.Items.First(); n != nil; n = n.Next() {
+			size += itemSize(n.Value.(map[string]AttributeValue))
+		}
+	}
+	gb := math.Floor(float64(size) / (1 << 30))
+	return ItemCollectionMetrics{
+		ItemCollectionKey:   map[string]AttributeValue{t.HashKey().AttributeName: key.hashKey},
+		SizeEstimateRangeGB: []float64{gb, gb + 1},
+	}
+}
+
+// walkItems calls fn with the items of the table in scan order, partition
+// by partition, until fn returns false. The walk starts at the first
+// partition which hash is at least from, or with a start key right after it,
+// even if the start item has been deleted since. fn is given the hash of the
+// partition of the item.
+func (t *Table) walkItems(from uint32, start *itemKey, fn func(hash uint32, item map[string]AttributeValue) bool) {
+	pn := t.partitions.Seek(partitionRef{hash: from})
+	var after *AttributeValue // Range key to resume after in the first partition
+	if start != nil {
+		ref := newPartitionRef(start.hash)
+		pn = t.partitions.Seek(ref)
+		if pn != nil && comparePartitionRefs(pn.Key, ref) == 0 {
+			after = &start.rangeKey
+		}
+	}
+
+	for ; pn != nil; pn = pn.Next() {
+		hash := pn.Key.(partitionRef).hash
+		items := pn.Value.(*partition).Items
+		n := items.First()
+		if after != nil {
+			n = items.Seek(*after)
+			if n != nil && t.compareRangeKeys(n.Key, *after) == 0 {
+				n = n.Next()
+			}
+			after = nil
+		}
+		for ; n != nil; n = n.Next() {
+			if !fn(hash, n.Value.(map[string]AttributeValue)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package dynamockdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Types below are encoded in the DynamoDB JSON 1.0 wire format. Binaries
+// are base64 encoded, timestamps are seconds since the epoch.
+
+// MarshalJSON encodes a as an object with a single member named after the
+// type of the value, for instance {"N":"5"}. An empty value is encoded as
+// null.
+func (a AttributeValue) MarshalJSON() ([]byte, error) {
+	var v interface{}
+	attributeType := a.Type()
+	switch attributeType {
+	case "":
+		return []byte("null"), nil
+	case StringAttributeType:
+		v = a.S
+	case NumberAttributeType:
+		v = a.N
+	case BinaryAttributeType:
+		v = a.B
+	case StringSetAttributeType:
+		v = a.SS
+	case NumberSetAttributeType:
+		v = a.NS
+	case BinarySetAttributeType:
+		v = a.BS
+	case MapAttributeType:
+		v = a.M
+	case ListAttributeType:
+		v = a.L
+	case BooleanAttributeType:
+		v = *a.BOOL
+	case NullAttributeType:
+		v = true
+	}
+	return json.Marshal(map[AttributeType]interface{}{attributeType: v})
+}
+
+// UnmarshalJSON decodes an attribute value object which must have exactly
+// one member, named after one of the supported types.
+func (a *AttributeValue) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	var members map[AttributeType]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return validationErrorf("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
+	}
+	if len(members) > 1 {
+		return validationErrorf("Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes")
+	}
+
+	*a = AttributeValue{}
+	for attributeType, raw := range members {
+		var v interface{}
+		switch attributeType {
+		case StringAttributeType:
+			v = &a.S
+		case NumberAttributeType:
+			v = &a.N
+		case BinaryAttributeType:
+			v = &a.B
+		case StringSetAttributeType:
+			v = &a.SS
+		case NumberSetAttributeType:
+			v = &a.NS
+		case BinarySetAttributeType:
+			v = &a.BS
+		case MapAttributeType:
+			v = &a.M
+		case ListAttributeType:
+			v = &a.L
+		case BooleanAttributeType:
+			v = &a.BOOL
+		case NullAttributeType:
+			v = &a.NULL
+		default:
+			return validationErrorf("Supplied AttributeValue has an unsupported datatype: %s", attributeType)
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+		if attributeType == NullAttributeType && !a.NULL {
+			return validationErrorf("One or more parameter values were invalid: Null attribute value types must have the value of true")
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes an expectation. Exists defaults to true when a
+// Value is given, as it does in DynamoDB.
+func (e *ExpectedAttributeValue) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Exists *bool
+		Value  AttributeValue
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	e.Value = v.Value
+	e.Exists = v.Value.Type() != ""
+	if v.Exists != nil {
+		e.Exists = *v.Exists
+	}
+	return nil
+}
+
+func (d ProvisionedThroughputDescription) MarshalJSON() ([]byte, error) {
+	type description ProvisionedThroughputDescription
+	return json.Marshal(struct {
+		description
+		LastDecreaseDateTime *float64 `json:",omitempty"`
+		LastIncreaseDateTime *float64 `json:",omitempty"`
+	}{description(d), epochSeconds(d.LastDecreaseDateTime), epochSeconds(d.LastIncreaseDateTime)})
+}
+
+func (d TableDescription) MarshalJSON() ([]byte, error) {
+	type description TableDescription
+	return json.Marshal(struct {
+		description
+		CreationDateTime *float64 `json:",omitempty"`
+	}{description(d), epochSeconds(d.CreationDateTime)})
+}
+
+// epochSeconds returns t as seconds since the epoch, or nil for the zero
+// time.
+func epochSeconds(t time.Time) *float64 {
+	if t.IsZero() {
+		return nil
+	}
+	seconds := float64(t.UnixNano()) / float64(time.Second)
+	return &seconds
+}
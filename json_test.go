@@ -0,0 +1,99 @@
+package dynamockdb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttributeValueJSON(t *testing.T) {
+	yes := true
+	cases := []struct {
+		value    AttributeValue
+		expected string
+	}{
+		{AttributeValue{S: "foo"}, `{"S":"foo"}`},
+		{AttributeValue{N: "5"}, `{"N":"5"}`},
+		{AttributeValue{B: []byte("foo")}, `{"B":"Zm9v"}`},
+		{AttributeValue{SS: []string{"a", "b"}}, `{"SS":["a","b"]}`},
+		{AttributeValue{NS: []string{"1", "2"}}, `{"NS":["1","2"]}`},
+		{AttributeValue{BS: [][]byte{[]byte("foo"), []byte("bar")}}, `{"BS":["Zm9v","YmFy"]}`},
+		{AttributeValue{M: map[string]AttributeValue{"a": AttributeValue{N: "1"}}}, `{"M":{"a":{"N":"1"}}}`},
+		{AttributeValue{M: map[string]AttributeValue{}}, `{"M":{}}`},
+		{AttributeValue{L: []AttributeValue{AttributeValue{S: "a"}, AttributeValue{NULL: true}}}, `{"L":[{"S":"a"},{"NULL":true}]}`},
+		{AttributeValue{L: []AttributeValue{}}, `{"L":[]}`},
+		{AttributeValue{BOOL: &yes}, `{"BOOL":true}`},
+		{AttributeValue{NULL: true}, `{"NULL":true}`},
+	}
+
+	for _, c := range cases {
+		data, err := json.Marshal(c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, data)
+		}
+
+		var decoded AttributeValue
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Type() != c.value.Type() || !decoded.Equal(&c.value) {
+			t.Fatalf("%s decoded to %+v", data, decoded)
+		}
+	}
+
+	invalid := []string{`{}`, `{"S":"a","N":"1"}`, `{"X":"a"}`, `{"NULL":false}`, `{"B":"not base64!"}`, `{"N":1}`}
+	for _, data := range invalid {
+		var decoded AttributeValue
+		if err := json.Unmarshal([]byte(data), &decoded); err == nil {
+			t.Fatalf("%s should be rejected, got %+v", data, decoded)
+		}
+	}
+}
+
+func TestExpectedAttributeValueJSON(t *testing.T) {
+	var expected map[string]ExpectedAttributeValue
+	data := `{"a": {"Value": {"S": "foo"}}, "b": {"Exists": false}, "c": {"Exists": true, "Value": {"N": "1"}}}`
+	if err := json.Unmarshal([]byte(data), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !expected["a"].Exists || expected["a"].Value.S != "foo" {
+		t.Fatalf("wrong expectation %+v", expected["a"])
+	}
+	if expected["b"].Exists {
+		t.Fatalf("wrong expectation %+v", expected["b"])
+	}
+	if !expected["c"].Exists || expected["c"].Value.N != "1" {
+		t.Fatalf("wrong expectation %+v", expected["c"])
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	data, err := json.Marshal(&GetItemResult{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{}` {
+		t.Fatalf("expected empty object, got %s", data)
+	}
+
+	data, err = json.Marshal(&PutItemResult{Attributes: map[string]AttributeValue{"id": AttributeValue{S: "foo"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"Attributes":{"id":{"S":"foo"}}}` {
+		t.Fatalf("wrong result %s", data)
+	}
+
+	desc := TableDescription{TableName: "bar", CreationDateTime: time.Unix(1380000000, 500000000)}
+	data, err = json.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"CreationDateTime":1380000000.5`) || strings.Contains(string(data), "LastDecreaseDateTime") {
+		t.Fatalf("wrong description %s", data)
+	}
+}
@@ -0,0 +1,239 @@
+package dynamockdb
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	numberMaxDigits = 38   // Significant digits
+	numberMinExp    = -130 // Smallest magnitude is 1E-130
+	numberMaxExp    = 125  // Largest magnitude is 9.99...E+125
+)
+
+var numberRegexp = regexp.MustCompile(`^([+-]?)([0-9]*)(?:\.([0-9]*))?(?:[eE]([+-]?[0-9]+))?$`)
+
+var bigTen = big.NewInt(10)
+
+// Number is a decimal number the way DynamoDB stores them: up to 38
+// significant digits and a magnitude between 1E-130 and 1E+126 (excluded),
+// or zero. Numbers are immutable.
+type Number struct {
+	coef *big.Int // Unscaled value, has no trailing zeros
+	exp  int      // Value is coef * 10^exp
+}
+
+// ParseNumber parses s, the representation of a number as sent in an
+// AttributeValue, and checks it fits in a DynamoDB number.
+func ParseNumber(s string) (Number, error) {
+	m := numberRegexp.FindStringSubmatch(s)
+	if m == nil || m[2] == "" && m[3] == "" {
+		return Number{}, validationErrorf("The parameter cannot be converted to a numeric value: %s", s)
+	}
+
+	exp := 0
+	if m[4] != "" {
+		e, err := strconv.Atoi(m[4])
+		// Anything that large can't hold in 38 digits anyway
+		if err != nil || e > 1e6 || e < -1e6 {
+			return Number{}, validationErrorf("The parameter cannot be converted to a numeric value: %s", s)
+		}
+		exp = e
+	}
+	exp -= len(m[3])
+
+	digits := strings.TrimLeft(m[2]+m[3], "0")
+	if digits == "" {
+		return Number{coef: new(big.Int)}, nil
+	}
+	trimmed := strings.TrimRight(digits, "0")
+	exp += len(digits) - len(trimmed)
+	digits = trimmed
+
+	coef, _ := new(big.Int).SetString(digits, 10)
+	if m[1] == "-" {
+		coef.Neg(coef)
+	}
+	return newNumber(coef, exp)
+}
+
+// newNumber normalizes coef and exp and checks they fit in a DynamoDB
+// number.
+func newNumber(coef *big.Int, exp int) (Number, error) {
+	if coef.Sign() == 0 {
+		return Number{coef: coef, exp: 0}, nil
+	}
+
+	r := new(big.Int)
+	for {
+		q, m := new(big.Int).QuoRem(coef, bigTen, r)
+		if m.Sign() != 0 {
+			break
+		}
+		coef = q
+		exp++
+	}
+
+	digits := len(new(big.Int).Abs(coef).String())
+	if digits > numberMaxDigits {
+		return Number{}, validationErrorf("Attempting to store more than %d significant digits in a Number", numberMaxDigits)
+	}
+
+	// Exponent of the number in scientific notation
+	adjusted := exp + digits - 1
+	if adjusted > numberMaxExp {
+		return Number{}, validationErrorf("Number overflow. Attempting to store a number with magnitude larger than supported range")
+	}
+	if adjusted < numberMinExp {
+		return Number{}, validationErrorf("Number underflow. Attempting to store a number with magnitude smaller than supported range")
+	}
+
+	return Number{coef: coef, exp: exp}, nil
+}
+
+func (n Number) IsZero() bool {
+	return n.coef == nil || n.coef.Sign() == 0
+}
+
+// String returns the canonical representation of n: plain decimal notation
+// with no leading or trailing zeros.
+func (n Number) String() string {
+	if n.IsZero() {
+		return "0"
+	}
+
+	sign := ""
+	if n.coef.Sign() < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(n.coef).String()
+
+	if n.exp >= 0 {
+		return sign + digits + strings.Repeat("0", n.exp)
+	}
+	point := len(digits) + n.exp
+	if point > 0 {
+		return sign + digits[:point] + "." + digits[point:]
+	}
+	return sign + "0." + strings.Repeat("0", -point) + digits
+}
+
+// Cmp returns -1, 0 or 1 when n is respectively lower, equal or greater
+// than m.
+func (n Number) Cmp(m Number) int {
+	a, b := n.align(m)
+	return a.Cmp(b)
+}
+
+// compareNumberStrings orders a and b without parsing them when both are in
+// the canonical form String returns: by sign, by the length of their integer
+// part, then digit per digit. It returns false if one of them is not
+// canonical.
+func compareNumberStrings(a, b string) (int, bool) {
+	if !canonicalNumber(a) || !canonicalNumber(b) {
+		return 0, false
+	}
+	negA, negB := a[0] == '-', b[0] == '-'
+	if negA != negB {
+		if negA {
+			return -1, true
+		}
+		return 1, true
+	}
+	a, b = strings.TrimPrefix(a, "-"), strings.TrimPrefix(b, "-")
+
+	cmp := 0
+	la, lb := integerLength(a), integerLength(b)
+	switch {
+	case la < lb:
+		cmp = -1
+	case la > lb:
+		cmp = 1
+	default:
+		cmp = strings.Compare(a, b)
+	}
+	if negA {
+		cmp = -cmp
+	}
+	return cmp, true
+}
+
+// canonicalNumber tells if s is a number in the form String returns: no
+// plus sign, exponent, leading or trailing zeros, and no negative zero.
+func canonicalNumber(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+		if s == "0" {
+			return false
+		}
+	}
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+		if fraction == "" || fraction[len(fraction)-1] == '0' {
+			return false
+		}
+	}
+	if integer == "" || len(integer) > 1 && integer[0] == '0' {
+		return false
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// integerLength returns the number of digits of the integer part of the
+// canonical positive number s.
+func integerLength(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+// Add returns n + m, or an error if the result does not fit in a number.
+func (n Number) Add(m Number) (Number, error) {
+	a, b := n.align(m)
+	return newNumber(a.Add(a, b), minInt(n.exp, m.exp))
+}
+
+// Sub returns n - m, or an error if the result does not fit in a number.
+func (n Number) Sub(m Number) (Number, error) {
+	a, b := n.align(m)
+	return newNumber(a.Sub(a, b), minInt(n.exp, m.exp))
+}
+
+// align returns the coefficients of n and m scaled to the same exponent.
+func (n Number) align(m Number) (*big.Int, *big.Int) {
+	a, b := n.bigCoef(), m.bigCoef()
+	exp := minInt(n.exp, m.exp)
+	a.Mul(a, new(big.Int).Exp(bigTen, big.NewInt(int64(n.exp-exp)), nil))
+	b.Mul(b, new(big.Int).Exp(bigTen, big.NewInt(int64(m.exp-exp)), nil))
+	return a, b
+}
+
+func (n Number) bigCoef() *big.Int {
+	if n.coef == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(n.coef)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package dynamockdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	cases := map[string]string{
+		"0":                                      "0",
+		"-0.000":                                 "0",
+		"007":                                    "7",
+		"+7":                                     "7",
+		"1.50":                                   "1.5",
+		"-1.50":                                  "-1.5",
+		".5":                                     "0.5",
+		"5.":                                     "5",
+		"1E+2":                                   "100",
+		"1.5e-3":                                 "0.0015",
+		"12300e-2":                               "123",
+		"99999999999999999999999999999999999999": "99999999999999999999999999999999999999",
+		"9.9999999999999999999999999999999999999E+125": "999999999999999999999999999999999999990000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		"0.00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000": "0",
+	}
+	cases["1E-130"] = "0." + strings.Repeat("0", 129) + "1"
+	for s, expected := range cases {
+		n, err := ParseNumber(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if n.String() != expected {
+			t.Fatalf("%s: expected %s, got %s", s, expected, n.String())
+		}
+	}
+
+	invalid := []string{"", "-", ".", "e5", "1e", "1.2.3", "abc", "1,5", " 1",
+		"1E+126", "-1E+126", "1E-131",
+		"123456789012345678901234567890123456789"}
+	for _, s := range invalid {
+		if n, err := ParseNumber(s); err == nil {
+			t.Fatalf("%q should not parse, got %s", s, n)
+		} else if !strings.HasPrefix(err.Error(), "ValidationException") {
+			t.Fatalf("%q: expected ValidationException, got %s", s, err)
+		}
+	}
+}
+
+func TestNumberArithmetic(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		sum, sub string
+	}{
+		{"1", "2", "3", "-1"},
+		{"0.1", "0.2", "0.3", "-0.1"},
+		{"10", "-10", "0", "20"},
+		{"1E+10", "1E-10", "10000000000.0000000001", "9999999999.9999999999"},
+	}
+	for _, c := range cases {
+		a, _ := ParseNumber(c.a)
+		b, _ := ParseNumber(c.b)
+		sum, err := a.Add(b)
+		if err != nil || sum.String() != c.sum {
+			t.Fatalf("%s + %s: expected %s, got %s %v", c.a, c.b, c.sum, sum, err)
+		}
+		sub, err := a.Sub(b)
+		if err != nil || sub.String() != c.sub {
+			t.Fatalf("%s - %s: expected %s, got %s %v", c.a, c.b, c.sub, sub, err)
+		}
+	}
+
+	// Overflow and precision
+
+	a, _ := ParseNumber("9.9999999999999999999999999999999999999E+125")
+	b, _ := ParseNumber("1E+88")
+	if _, err := a.Add(b); err == nil {
+		t.Fatalf("overflow expected")
+	}
+	a, _ = ParseNumber("1E+20")
+	b, _ = ParseNumber("1E-20")
+	if _, err := a.Add(b); err == nil {
+		t.Fatalf("more than 38 digits expected")
+	}
+}
+
+func TestCompareNumberStrings(t *testing.T) {
+	// Canonical numbers, in ascending order
+	numbers := []string{"-100", "-12.5", "-12.25", "-12", "-9.99", "-1", "-0.5", "-0.05", "0", "0.05", "0.5", "1", "1.05", "1.5", "9.99", "12", "12.25", "12.5", "100"}
+	for i, a := range numbers {
+		na, _ := ParseNumber(a)
+		if na.String() != a {
+			t.Fatalf("%s is not canonical", a)
+		}
+		for j, b := range numbers {
+			nb, _ := ParseNumber(b)
+			cmp, ok := compareNumberStrings(a, b)
+			expected := na.Cmp(nb)
+			if !ok || cmp != expected || (i < j) != (cmp < 0) {
+				t.Fatalf("%s vs %s: expected %d, got %d %v", a, b, expected, cmp, ok)
+			}
+		}
+	}
+
+	for _, s := range []string{"+1", "01", "1.50", "1.", ".5", "-0", "1E+2", "1e2", "", "-", "1.2.3", "abc"} {
+		if _, ok := compareNumberStrings(s, "1"); ok {
+			t.Fatalf("%q should not be compared as canonical", s)
+		}
+	}
+}
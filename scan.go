@@ -0,0 +1,208 @@
+package dynamockdb
+
+import "sort"
+
+// maxPageSize is the amount of data read by a Scan or a Query before it
+// returns a page.
+const maxPageSize = 1 << 20
+
+// page gathers the items read by a Scan or a Query. Every item read counts
+// toward Limit and the page size, the filter only drops items from the page.
+type page struct {
+	limit   int
+	filter  condition
+	project func(map[string]AttributeValue) map[string]AttributeValue // Nil to only count items
+
+	items   []map[string]AttributeValue
+	count   int
+	scanned int
+	size    int
+	last    map[string]AttributeValue // Item the page stopped at, nil if the reads ran out first
+}
+
+// add reads item into the page and tells if more items can be read. A page
+// is full once it reaches Limit or the page size, and then has a
+// LastEvaluatedKey even if no item follows.
+func (p *page) add(item map[string]AttributeValue) bool {
+	p.size += itemSize(item)
+	p.scanned++
+	if p.filter == nil || p.filter.eval(item) {
+		p.count++
+		if p.project != nil {
+			p.items = append(p.items, p.project(item))
+		}
+	}
+	if p.limit > 0 && p.scanned == p.limit || p.size >= maxPageSize {
+		p.last = item
+		return false
+	}
+	return true
+}
+
+// consumedCapacity returns the capacity the reads of the page consumed on t,
+// or nil if it is not returned.
+func (p *page) consumedCapacity(t *Table, returnConsumedCapacity ReturnConsumedCapacity, consistentRead bool) *ConsumedCapacity {
+	if returnConsumedCapacity != TotalReturnConsumedCapacity {
+		return nil
+	}
+	return &ConsumedCapacity{
+		CapacityUnits: readCapacity(p.size, consistentRead),
+		TableName:     t.TableDescription.TableName,
+	}
+}
+
+func (t *Table) Scan(req *ScanRequest) (*ScanResult, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.scan(req)
+}
+
+func (t *Table) scan(req *ScanRequest) (*ScanResult, error) {
+	if req.Limit < 0 {
+		return nil, validationErrorf("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", req.Limit)
+	}
+	if err := validateSelect(req.Select, req.AttributesToGet, req.ProjectionExpression, ""); err != nil {
+		return nil, err
+	}
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	paths, err := parseReadProjection(req.AttributesToGet, req.ProjectionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := t.parseFilter(req.ScanFilter, req.ConditionalOperator, req.FilterExpression, attrs, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	if err := validateSegment(req.Segment, req.TotalSegments); err != nil {
+		return nil, err
+	}
+
+	// A segment covers a contiguous span of partitions
+	var from uint32
+	if req.TotalSegments > 0 {
+		from = segmentStart(req.Segment, req.TotalSegments)
+	}
+
+	var start *itemKey
+	if len(req.ExclusiveStartKey) > 0 {
+		key, err := t.exclusiveStartKey(req.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		start = &key
+	}
+
+	var project func(map[string]AttributeValue) map[string]AttributeValue
+	if req.Select != CountQuerySelect {
+		project = func(item map[string]AttributeValue) map[string]AttributeValue {
+			return projectItem(item, paths)
+		}
+	}
+	pg := &page{limit: req.Limit, filter: filter, project: project}
+	t.walkItems(from, start, func(hash uint32, item map[string]AttributeValue) bool {
+		if req.TotalSegments > 0 && segmentOf(hash, req.TotalSegments) != req.Segment {
+			return false
+		}
+		return pg.add(item)
+	})
+
+	result := &ScanResult{
+		ConsumedCapacity: pg.consumedCapacity(t, req.ReturnConsumedCapacity, req.ConsistentRead),
+		Count:            pg.count,
+		Items:            pg.items,
+		ScannedCount:     pg.scanned,
+	}
+	if pg.last != nil {
+		key, _ := t.keyOf(pg.last)
+		result.LastEvaluatedKey = key.attributes(t)
+	}
+	return result, nil
+}
+
+// parseFilter parses the filter of a Scan or a Query, given either with
+// the legacy ScanFilter or QueryFilter map, or as a FilterExpression. It
+// returns nil if there is none. A Query can't filter on the primary key.
+func (t *Table) parseFilter(filter map[string]Condition, conditionalOperator ConditionalOperator, expression string, attrs expressionAttributes, query bool) (condition, error) {
+	param := "ScanFilter"
+	if query {
+		param = "QueryFilter"
+	}
+
+	var c condition
+	var names []string
+	var err error
+	switch {
+	case expression != "" && len(filter) > 0:
+		return nil, validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {%s} Expression parameters: {FilterExpression}", param)
+	case expression != "":
+		if conditionalOperator != "" {
+			return nil, validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {ConditionalOperator} Expression parameters: {FilterExpression}")
+		}
+		c, names, err = parseConditionExpression("FilterExpression", expression, attrs)
+	default:
+		c, err = parseLegacyFilter(param, filter, conditionalOperator)
+		for name := range filter {
+			names = append(names, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if query {
+		label := param
+		if expression != "" {
+			label = "Filter Expression"
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if t.isKeyAttribute(name) {
+				return nil, validationErrorf("%s can only contain non-primary key attributes: Primary key attribute: %s", label, name)
+			}
+		}
+	}
+	return c, nil
+}
+
+// exclusiveStartKey returns the key a Scan or a Query resumes after. It must
+// hold the primary key and nothing else.
+func (t *Table) exclusiveStartKey(attrs map[string]AttributeValue) (itemKey, error) {
+	key, err := t.parseKey(attrs)
+	if err != nil {
+		return itemKey{}, validationErrorf("The provided starting key is invalid: %s", errorMessage(err))
+	}
+	return key, nil
+}
+
+// Partition hashes are split evenly between segments. Segment i of n owns
+// the hashes h for which h * n / 2^32 is i.
+
+func segmentOf(hash uint32, totalSegments int) int {
+	return int(uint64(hash) * uint64(totalSegments) >> 32)
+}
+
+// segmentStart returns the smallest hash owned by segment.
+func segmentStart(segment, totalSegments int) uint32 {
+	n := uint64(totalSegments)
+	return uint32((uint64(segment)<<32 + n - 1) / n)
+}
+
+func validateSegment(segment, totalSegments int) error {
+	if totalSegments < 0 || totalSegments > 1000000 {
+		return validationErrorf("1 validation error detected: Value '%d' at 'totalSegments' failed to satisfy constraint: Member must have value less than or equal to 1000000 and greater than or equal to 1", totalSegments)
+	}
+	if segment < 0 {
+		return validationErrorf("1 validation error detected: Value '%d' at 'segment' failed to satisfy constraint: Member must have value greater than or equal to 0", segment)
+	}
+	if totalSegments == 0 && segment > 0 {
+		return validationErrorf("The TotalSegments parameter is required but was not present in the request when Segment parameter is present")
+	}
+	if totalSegments > 0 && segment >= totalSegments {
+		return validationErrorf("The Segment parameter is zero-based and must be less than parameter TotalSegments: Segment: %d is out of bounds of TotalSegments: %d", segment, totalSegments)
+	}
+	return nil
+}
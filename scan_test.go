@@ -0,0 +1,402 @@
+package dynamockdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for h := 0; h < 5; h++ {
+		for r := 0; r < 4; r++ {
+			InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint("h", h)}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "foo": AttributeValue{S: fmt.Sprint(h, r)}})
+		}
+	}
+
+	result, err := table.Scan(&ScanRequest{TableName: "bax"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 20 || result.ScannedCount != 20 || len(result.Items) != 20 || result.LastEvaluatedKey != nil {
+		t.Fatalf("wrong scan result %+v", result)
+	}
+
+	// Pages cover all items exactly once
+
+	seen := make(map[string]int)
+	var startKey map[string]AttributeValue
+	pages := 0
+	for {
+		result, err := table.Scan(&ScanRequest{TableName: "bax", Limit: 3, ExclusiveStartKey: startKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if result.Count > 3 {
+			t.Fatalf("page over the limit %+v", result)
+		}
+		for _, item := range result.Items {
+			seen[item["foo"].S]++
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		if len(result.LastEvaluatedKey) != 2 {
+			t.Fatalf("LastEvaluatedKey should be the primary key, got %+v", result.LastEvaluatedKey)
+		}
+		startKey = result.LastEvaluatedKey
+	}
+	if pages != 7 || len(seen) != 20 {
+		t.Fatalf("expected 20 items in 7 pages, got %d in %d", len(seen), pages)
+	}
+	for foo, n := range seen {
+		if n != 1 {
+			t.Fatalf("item %s seen %d times", foo, n)
+		}
+	}
+
+	// A page that reaches Limit has a LastEvaluatedKey even when it ends the
+	// table, the next page is empty
+
+	result, _ = table.Scan(&ScanRequest{TableName: "bax", Limit: 20})
+	if result.Count != 20 || result.LastEvaluatedKey == nil {
+		t.Fatalf("expected a full page with a LastEvaluatedKey, got %d items and %+v", result.Count, result.LastEvaluatedKey)
+	}
+	result, _ = table.Scan(&ScanRequest{TableName: "bax", Limit: 20, ExclusiveStartKey: result.LastEvaluatedKey})
+	if result.Count != 0 || result.ScannedCount != 0 || result.LastEvaluatedKey != nil {
+		t.Fatalf("expected an empty last page, got %+v", result)
+	}
+
+	// Resuming after a deleted item
+
+	page, _ := table.Scan(&ScanRequest{TableName: "bax", Limit: 10})
+	DeleteItemWithKey(table, page.LastEvaluatedKey)
+	rest, _ := table.Scan(&ScanRequest{TableName: "bax", ExclusiveStartKey: page.LastEvaluatedKey})
+	if rest.Count != 10 {
+		t.Fatalf("expected 10 items after the deleted start key, got %d", rest.Count)
+	}
+
+	// Invalid start key
+
+	_, err = table.Scan(&ScanRequest{TableName: "bax", ExclusiveStartKey: map[string]AttributeValue{"id": AttributeValue{S: "h1"}}})
+	if err == nil || !strings.HasPrefix(err.Error(), "ValidationException: The provided starting key is invalid") {
+		t.Fatalf("expected invalid starting key, got %v", err)
+	}
+}
+
+func TestScanSelect(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}, "other": AttributeValue{N: "1"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "ba"}, "foo": AttributeValue{S: "bar"}})
+
+	result, err := table.Scan(&ScanRequest{TableName: "bax", Select: CountQuerySelect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 2 || result.ScannedCount != 2 || result.Items != nil {
+		t.Fatalf("wrong count result %+v", result)
+	}
+
+	result, err = table.Scan(&ScanRequest{TableName: "bax", AttributesToGet: []string{"foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectItems(t, result.Items, "foo", "bam", "bar")
+	for _, item := range result.Items {
+		if len(item) != 1 {
+			t.Fatalf("only foo should be returned, got %+v", item)
+		}
+	}
+
+	if _, err = table.Scan(&ScanRequest{TableName: "bax", Select: CountQuerySelect, AttributesToGet: []string{"foo"}}); err == nil {
+		t.Fatalf("COUNT with AttributesToGet should be rejected")
+	}
+	if _, err = table.Scan(&ScanRequest{TableName: "bax", Select: AllProjectedAttributesQuerySelect}); err == nil {
+		t.Fatalf("ALL_PROJECTED_ATTRIBUTES without an index should be rejected")
+	}
+}
+
+func TestScanFilterExpression(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for h := 0; h < 5; h++ {
+		for r := 0; r < 4; r++ {
+			InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint("h", h)}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "foo": AttributeValue{S: fmt.Sprint(h, r)}})
+		}
+	}
+	values := map[string]AttributeValue{":v": AttributeValue{S: "4 2"}}
+
+	result, err := table.Scan(&ScanRequest{TableName: "bax", FilterExpression: "foo = :v", ExpressionAttributeValues: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 1 || result.ScannedCount != 20 || len(result.Items) != 1 || result.Items[0]["foo"].S != "4 2" {
+		t.Fatalf("wrong filtered scan %+v", result)
+	}
+
+	// The filter applies after Limit, pages can be empty and still have a
+	// LastEvaluatedKey
+
+	var startKey map[string]AttributeValue
+	pages, empty, count, scanned := 0, 0, 0, 0
+	for {
+		result, err := table.Scan(&ScanRequest{TableName: "bax", Limit: 3, ExclusiveStartKey: startKey, FilterExpression: "foo = :v", ExpressionAttributeValues: values})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		count += result.Count
+		scanned += result.ScannedCount
+		if result.Count == 0 && result.LastEvaluatedKey != nil {
+			empty++
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = result.LastEvaluatedKey
+	}
+	if pages != 7 || count != 1 || scanned != 20 || empty < 5 {
+		t.Fatalf("expected 1 item in 7 pages, got %d in %d pages (%d scanned, %d empty)", count, pages, scanned, empty)
+	}
+
+	// Invalid filters
+
+	for _, req := range []*ScanRequest{
+		{TableName: "bax", FilterExpression: "foo = :w", ExpressionAttributeValues: values},
+		{TableName: "bax", FilterExpression: "foo", ExpressionAttributeValues: values},
+		{TableName: "bax", FilterExpression: "foo = :v"},
+		{TableName: "bax", ExpressionAttributeValues: values},
+	} {
+		if _, err := table.Scan(req); err == nil {
+			t.Fatalf("filter %q should fail", req.FilterExpression)
+		}
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{
+		"id": AttributeValue{S: "a"},
+		"n":  AttributeValue{N: "5"},
+		"s":  AttributeValue{S: "hello"},
+		"b":  AttributeValue{B: []byte("abc")},
+		"ss": AttributeValue{SS: []string{"x", "y"}},
+		"ns": AttributeValue{NS: []string{"1", "2"}},
+		"l":  AttributeValue{L: []AttributeValue{AttributeValue{S: "x"}}},
+	})
+	InsertItem(table, "bax", map[string]AttributeValue{
+		"id": AttributeValue{S: "b"},
+		"n":  AttributeValue{N: "10"},
+		"s":  AttributeValue{S: "world"},
+		"ss": AttributeValue{SS: []string{"z"}},
+		"ns": AttributeValue{NS: []string{"3"}},
+	})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "c"}})
+
+	n := func(v string) AttributeValue { return AttributeValue{N: v} }
+	s := func(v string) AttributeValue { return AttributeValue{S: v} }
+	for _, c := range []struct {
+		name      string
+		condition Condition
+		expected  []string
+	}{
+		{"n", Condition{EQ, []AttributeValue{n("5")}}, []string{"a"}},
+		{"n", Condition{NE, []AttributeValue{n("5")}}, []string{"b", "c"}},
+		{"n", Condition{GT, []AttributeValue{n("5")}}, []string{"b"}},
+		{"n", Condition{LE, []AttributeValue{n("10")}}, []string{"a", "b"}},
+		{"n", Condition{LT, []AttributeValue{n("10")}}, []string{"a"}},
+		{"n", Condition{GE, []AttributeValue{n("05")}}, []string{"a", "b"}},
+		{"n", Condition{GT, []AttributeValue{s("1")}}, nil},
+		{"n", Condition{BETWEEN, []AttributeValue{n("4"), n("6")}}, []string{"a"}},
+		{"n", Condition{IN, []AttributeValue{n("5"), n("10"), s("5")}}, []string{"a", "b"}},
+		{"n", Condition{NULL, nil}, []string{"c"}},
+		{"n", Condition{NOT_NULL, nil}, []string{"a", "b"}},
+		{"s", Condition{BEGINS_WITH, []AttributeValue{s("he")}}, []string{"a"}},
+		{"s", Condition{CONTAINS, []AttributeValue{s("orl")}}, []string{"b"}},
+		{"s", Condition{NOT_CONTAINS, []AttributeValue{s("orl")}}, []string{"a", "c"}},
+		{"b", Condition{BEGINS_WITH, []AttributeValue{AttributeValue{B: []byte("ab")}}}, []string{"a"}},
+		{"b", Condition{CONTAINS, []AttributeValue{AttributeValue{B: []byte("bc")}}}, []string{"a"}},
+		{"ss", Condition{CONTAINS, []AttributeValue{s("y")}}, []string{"a"}},
+		{"ss", Condition{NOT_CONTAINS, []AttributeValue{s("y")}}, []string{"b", "c"}},
+		{"ss", Condition{EQ, []AttributeValue{AttributeValue{SS: []string{"y", "x"}}}}, []string{"a"}},
+		{"ns", Condition{CONTAINS, []AttributeValue{n("3")}}, []string{"b"}},
+		{"ns", Condition{CONTAINS, []AttributeValue{s("3")}}, nil},
+		{"l", Condition{CONTAINS, []AttributeValue{s("x")}}, []string{"a"}},
+	} {
+		result, err := table.Scan(&ScanRequest{TableName: "bax", ScanFilter: map[string]Condition{c.name: c.condition}})
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.name, c.condition.ConditionOperator, err)
+		}
+		if result.ScannedCount != 3 {
+			t.Fatalf("%s %s: expected 3 scanned items, got %d", c.name, c.condition.ConditionOperator, result.ScannedCount)
+		}
+		ExpectItems(t, result.Items, "id", c.expected...)
+	}
+
+	// Conditions are and-ed unless ConditionalOperator is OR
+
+	filter := map[string]Condition{
+		"n": Condition{EQ, []AttributeValue{n("5")}},
+		"s": Condition{EQ, []AttributeValue{s("world")}},
+	}
+	for operator, expected := range map[ConditionalOperator][]string{"": nil, AndConditionalOperator: nil, OrConditionalOperator: []string{"a", "b"}} {
+		result, err := table.Scan(&ScanRequest{TableName: "bax", ScanFilter: filter, ConditionalOperator: operator})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ExpectItems(t, result.Items, "id", expected...)
+	}
+
+	// Invalid filters
+
+	for _, c := range []struct {
+		req     *ScanRequest
+		message string
+	}{
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{GT, []AttributeValue{AttributeValue{SS: []string{"x"}}}}}}, "One or more parameter values were invalid: ComparisonOperator GT is not valid for SS AttributeValue type"},
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{BEGINS_WITH, []AttributeValue{n("1")}}}}, "One or more parameter values were invalid: ComparisonOperator BEGINS_WITH is not valid for N AttributeValue type"},
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{NULL, []AttributeValue{n("1")}}}}, "One or more parameter values were invalid: Invalid number of argument(s) for the NULL ComparisonOperator"},
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{BETWEEN, []AttributeValue{n("1")}}}}, "One or more parameter values were invalid: Invalid number of argument(s) for the BETWEEN ComparisonOperator"},
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{IN, nil}}}, "One or more parameter values were invalid: Invalid number of argument(s) for the IN ComparisonOperator"},
+		{&ScanRequest{ScanFilter: map[string]Condition{"n": Condition{"FOO", []AttributeValue{n("1")}}}}, "1 validation error detected: Value 'FOO' at 'scanFilter.n.member.comparisonOperator' failed to satisfy constraint: Member must satisfy enum value set: [IN, NULL, BETWEEN, LT, NOT_CONTAINS, EQ, GT, NOT_NULL, NE, LE, BEGINS_WITH, GE, CONTAINS]"},
+		{&ScanRequest{ScanFilter: filter, ConditionalOperator: "XOR"}, "1 validation error detected: Value 'XOR' at 'conditionalOperator' failed to satisfy constraint: Member must satisfy enum value set: [OR, AND]"},
+		{&ScanRequest{ScanFilter: filter, FilterExpression: "attribute_exists(n)"}, "Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {ScanFilter} Expression parameters: {FilterExpression}"},
+	} {
+		c.req.TableName = "bax"
+		_, err := table.Scan(c.req)
+		if err == nil || errorMessage(err) != c.message {
+			t.Fatalf("expected %q, got %v", c.message, err)
+		}
+	}
+}
+
+func TestScanPageSize(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	big := strings.Repeat("x", 100*1024)
+	for i := 0; i < 15; i++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}, "foo": AttributeValue{S: big}})
+	}
+
+	result, err := table.Scan(&ScanRequest{TableName: "bax", ReturnConsumedCapacity: TotalReturnConsumedCapacity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 11 || result.LastEvaluatedKey == nil {
+		t.Fatalf("expected the page to stop after 1MB, got %d items", result.Count)
+	}
+	// About 1.1MB eventually consistent
+	if result.ConsumedCapacity == nil || result.ConsumedCapacity.CapacityUnits != 138 || result.ConsumedCapacity.TableName != "bax" {
+		t.Fatalf("wrong consumed capacity %+v", result.ConsumedCapacity)
+	}
+
+	result, err = table.Scan(&ScanRequest{TableName: "bax", ExclusiveStartKey: result.LastEvaluatedKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 4 || result.LastEvaluatedKey != nil {
+		t.Fatalf("expected the 4 remaining items, got %d", result.Count)
+	}
+}
+
+func DeleteItemWithKey(table *Table, key map[string]AttributeValue) {
+	_, err := table.DeleteItem(&DeleteItemRequest{Key: key})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for h := 0; h < 50; h++ {
+		for r := 0; r < 3; r++ {
+			InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint("h", h)}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "foo": AttributeValue{S: fmt.Sprint(h, "-", r)}})
+		}
+	}
+
+	totalSegments := 4
+	segments := make([][]map[string]AttributeValue, totalSegments)
+	errs := make(chan error, totalSegments)
+	for segment := 0; segment < totalSegments; segment++ {
+		go func(segment int) {
+			var startKey map[string]AttributeValue
+			for {
+				result, err := table.Scan(&ScanRequest{TableName: "bax", Limit: 7, Segment: segment, TotalSegments: totalSegments, ExclusiveStartKey: startKey})
+				if err != nil {
+					errs <- err
+					return
+				}
+				segments[segment] = append(segments[segment], result.Items...)
+				if result.LastEvaluatedKey == nil {
+					errs <- nil
+					return
+				}
+				startKey = result.LastEvaluatedKey
+			}
+		}(segment)
+	}
+	for i := 0; i < totalSegments; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]int)
+	for segment, items := range segments {
+		if len(items) == 0 {
+			t.Fatalf("segment %d is empty", segment)
+		}
+		// All the items of a hash key are in the same segment
+		for _, item := range items {
+			seen[item["foo"].S]++
+			for other := range segments {
+				if other != segment {
+					for _, otherItem := range segments[other] {
+						if otherItem["id"].S == item["id"].S {
+							t.Fatalf("hash key %s in segments %d and %d", item["id"].S, segment, other)
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(seen) != 150 {
+		t.Fatalf("expected 150 items across segments, got %d", len(seen))
+	}
+	for foo, n := range seen {
+		if n != 1 {
+			t.Fatalf("item %s seen %d times", foo, n)
+		}
+	}
+
+	// A single segment is the whole table
+
+	result, _ := table.Scan(&ScanRequest{TableName: "bax", Segment: 0, TotalSegments: 1})
+	if result.Count != 150 {
+		t.Fatalf("expected 150 items in the single segment, got %d", result.Count)
+	}
+
+	// Invalid segments
+
+	for _, req := range []*ScanRequest{
+		&ScanRequest{TableName: "bax", Segment: 4, TotalSegments: 4},
+		&ScanRequest{TableName: "bax", Segment: 1},
+		&ScanRequest{TableName: "bax", Segment: -1, TotalSegments: 4},
+		&ScanRequest{TableName: "bax", TotalSegments: 1000001},
+	} {
+		if _, err := table.Scan(req); err == nil {
+			t.Fatalf("segment %d of %d should be rejected", req.Segment, req.TotalSegments)
+		}
+	}
+}
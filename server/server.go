@@ -1,88 +1,41 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"github.com/nicolaspaton/dynamockdb"
-	"io"
 	"log"
 	"net/http"
-	"strings"
 )
 
-var db *dynamockdb.DB
+var (
+	addr                = flag.String("addr", ":3300", "address to listen on")
+	unprocessedEvery    = flag.Int("unprocessed-every", 0, "leave every Nth item of batch calls unprocessed")
+	unprocessedFraction = flag.Float64("unprocessed-fraction", 0, "fraction of the items of batch calls left unprocessed")
+	unprocessedSeed     = flag.Int64("unprocessed-seed", 1, "seed for -unprocessed-fraction")
+)
 
 func main() {
-	http.HandleFunc("/", Handler)
+	flag.Parse()
 
-	db = dynamockdb.NewDB()
+	db := dynamockdb.NewDB()
 	req := &dynamockdb.CreateTableRequest{
-		AttributeDefinitions:  []dynamockdb.AttributeDefinition{dynamockdb.AttributeDefinition{AttributeName: "foo", AttributeType: dynamockdb.StringAttributeType}},
+		AttributeDefinitions:  []dynamockdb.AttributeDefinition{dynamockdb.AttributeDefinition{AttributeName: "id", AttributeType: dynamockdb.StringAttributeType}},
 		KeySchema:             []dynamockdb.KeySchemaElement{dynamockdb.KeySchemaElement{AttributeName: "id", KeyType: dynamockdb.HashKeyType}},
 		ProvisionedThroughput: dynamockdb.ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
 		TableName:             "bar",
 	}
-	db.CreateTable(req)
-
-	log.Println("Starting dynamockdb")
-	log.Fatal(http.ListenAndServe(":3300", nil))
-}
-
-func Handler(w http.ResponseWriter, r *http.Request) {
-
-	dec := json.NewDecoder(r.Body)
-	enc := json.NewEncoder(w)
-
-	if r.Header["X-Amz-Target"] == nil {
-		http.Error(w, "Missing X-Amz-Target", 400)
-		return
+	if _, err := db.CreateTable(req); err != nil {
+		log.Fatal(err)
 	}
 
-	t := strings.Split(r.Header["X-Amz-Target"][0], ".")
-	cmd := t[len(t)-1:][0]
-	switch cmd {
-	case "ListTables":
-		req := &dynamockdb.ListTablesRequest{}
-		err := dec.Decode(req)
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
-			}
-		}
-		log.Printf("ListTables %#+v", req)
-		res := db.ListTables(req)
-		log.Printf("%#+v", res)
+	db.SetUnprocessedPolicy(dynamockdb.UnprocessedPolicy{
+		Every:    *unprocessedEvery,
+		Fraction: *unprocessedFraction,
+		Seed:     *unprocessedSeed,
+	})
 
-		err = enc.Encode(res)
-		if err != nil {
-			panic(err)
-		}
-	case "DescribeTable":
-		// buf := make([]byte, 10000)
-		// n, err := r.Body.Read(buf)
-		// if err != nil {
-		// 	panic(err)
-		// }
-		// log.Println(string(buf[:n]))
-		
-		req := &dynamockdb.DescribeTableRequest{}
-		err := dec.Decode(req)
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
-			}
-		}
-		log.Printf("Describe: %#+v", req.TableName)
-		res := db.DescribeTable(req)
-		log.Printf("%#+v", res)
+	http.Handle("/", dynamockdb.NewHandler(db))
 
-		err = enc.Encode(res)
-		if err != nil {
-			panic(err)
-		}
-	default:
-		http.Error(w, "Uknown X-Amz-Target", 400)
-		return
-	}
-	// dec := json.NewDecoder(r.Body)
-	// dec.Decode(v)
+	log.Println("Starting dynamockdb on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
 }
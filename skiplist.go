@@ -0,0 +1,169 @@
+package dynamockdb
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+// skipList is an ordered map. Keys are ordered using the compare function
+// given to newSkipList which must return a negative number, zero or a
+// positive number when a is respectively lower, equal or greater than b.
+//
+// Lookups, inserts and deletes are O(log n). Nodes can be walked in both
+// directions.
+type skipList struct {
+	head    *skipNode
+	level   int
+	length  int
+	compare func(a, b interface{}) int
+	rand    *rand.Rand
+}
+
+type skipNode struct {
+	Key   interface{}
+	Value interface{}
+	next  []*skipNode
+	prev  *skipNode // Previous node on level 0, nil for the first one
+}
+
+// Next returns the node following n or nil if n is the last one.
+func (n *skipNode) Next() *skipNode {
+	return n.next[0]
+}
+
+// Prev returns the node preceding n or nil if n is the first one.
+func (n *skipNode) Prev() *skipNode {
+	return n.prev
+}
+
+func newSkipList(compare func(a, b interface{}) int) *skipList {
+	return &skipList{
+		head:    &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level:   1,
+		compare: compare,
+		rand:    rand.New(rand.NewSource(1)),
+	}
+}
+
+func (l *skipList) Len() int {
+	return l.length
+}
+
+// First returns the node with the lowest key or nil if the list is empty.
+func (l *skipList) First() *skipNode {
+	return l.head.next[0]
+}
+
+// Last returns the node with the highest key or nil if the list is empty.
+func (l *skipList) Last() *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			x = x.next[i]
+		}
+	}
+	if x == l.head {
+		return nil
+	}
+	return x
+}
+
+// Seek returns the first node which key is greater or equal to key, or nil.
+func (l *skipList) Seek(key interface{}) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.compare(x.next[i].Key, key) < 0 {
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
+
+func (l *skipList) Get(key interface{}) (interface{}, bool) {
+	n := l.Seek(key)
+	if n == nil || l.compare(n.Key, key) != 0 {
+		return nil, false
+	}
+	return n.Value, true
+}
+
+// Set stores value under key, replacing any value already there. It returns
+// true if key was not in the list yet.
+func (l *skipList) Set(key, value interface{}) bool {
+	var update [skipListMaxLevel]*skipNode
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.compare(x.next[i].Key, key) < 0 {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	if n := x.next[0]; n != nil && l.compare(n.Key, key) == 0 {
+		n.Value = value
+		return false
+	}
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	n := &skipNode{Key: key, Value: value, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	if update[0] != l.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	}
+	l.length++
+	return true
+}
+
+// Delete removes key from the list and returns the value it held.
+func (l *skipList) Delete(key interface{}) (interface{}, bool) {
+	var update [skipListMaxLevel]*skipNode
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.compare(x.next[i].Key, key) < 0 {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	n := x.next[0]
+	if n == nil || l.compare(n.Key, key) != 0 {
+		return nil, false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+	return n.Value, true
+}
+
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && l.rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,95 @@
+package dynamockdb
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func compareInts(a, b interface{}) int {
+	return a.(int) - b.(int)
+}
+
+func TestSkipList(t *testing.T) {
+	l := newSkipList(compareInts)
+	keys := rand.New(rand.NewSource(42)).Perm(1000)
+	for _, k := range keys {
+		if !l.Set(k, k*2) {
+			t.Fatalf("key %d already in list", k)
+		}
+	}
+	if l.Set(10, 42) {
+		t.Fatalf("key 10 should have been replaced")
+	}
+	if l.Len() != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", l.Len())
+	}
+
+	// Ordered walk
+
+	i := 0
+	for n := l.First(); n != nil; n = n.Next() {
+		if n.Key.(int) != i {
+			t.Fatalf("expected key %d, got %d", i, n.Key)
+		}
+		i++
+	}
+
+	// Lookups
+
+	if v, ok := l.Get(10); !ok || v.(int) != 42 {
+		t.Fatalf("wrong value for 10: %v", v)
+	}
+	if _, ok := l.Get(1000); ok {
+		t.Fatalf("1000 should not be found")
+	}
+
+	// Deletes
+
+	for _, k := range keys[:500] {
+		if _, ok := l.Delete(k); !ok {
+			t.Fatalf("key %d not deleted", k)
+		}
+	}
+	if _, ok := l.Delete(keys[0]); ok {
+		t.Fatalf("key %d deleted twice", keys[0])
+	}
+	if l.Len() != 500 {
+		t.Fatalf("expected 500 keys, got %d", l.Len())
+	}
+
+	left := append([]int{}, keys[500:]...)
+	sort.Ints(left)
+	i = 0
+	for n := l.First(); n != nil; n = n.Next() {
+		if n.Key.(int) != left[i] {
+			t.Fatalf("expected key %d, got %d", left[i], n.Key)
+		}
+		i++
+	}
+
+	// Reverse walk
+
+	i = len(left) - 1
+	for n := l.Last(); n != nil; n = n.Prev() {
+		if n.Key.(int) != left[i] {
+			t.Fatalf("expected key %d, got %d", left[i], n.Key)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("reverse walk stopped at %d", i)
+	}
+
+	// Seek
+
+	if n := l.Seek(left[10]); n == nil || n.Key.(int) != left[10] {
+		t.Fatalf("wrong seek result %v", n)
+	}
+	if n := l.Seek(-1); n == nil || n.Key.(int) != left[0] {
+		t.Fatalf("wrong seek result %v", n)
+	}
+	if n := l.Seek(1000); n != nil {
+		t.Fatalf("seek past the end should be nil, got %v", n.Key)
+	}
+}
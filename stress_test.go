@@ -0,0 +1,182 @@
+package dynamockdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with -race.
+
+func TestConcurrentAdd(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+
+	workers, adds := 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				_, err := table.UpdateItem(&UpdateItemRequest{
+					Key:              map[string]AttributeValue{"id": AttributeValue{S: "counter"}},
+					AttributeUpdates: map[string]AttributeValueUpdate{"n": AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{N: "1"}}},
+					TableName:        "bax",
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := table.GetItem(&GetItemRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "counter"}}, TableName: "bax"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprint(workers * adds); result.Item["n"].N != expected {
+		t.Fatalf("expected counter at %s, got %s", expected, result.Item["n"].N)
+	}
+}
+
+func TestConcurrentConditionalPut(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+
+	workers := 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, failed := 0, 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			_, err := table.PutItem(&PutItemRequest{
+				Item:      map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "owner": AttributeValue{N: fmt.Sprint(w)}},
+				Expected:  map[string]ExpectedAttributeValue{"id": ExpectedAttributeValue{Exists: false}},
+				TableName: "bax",
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			var failedCheck *ConditionalCheckFailedException
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &failedCheck):
+				failed++
+			default:
+				t.Error(err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if succeeded != 1 || failed != workers-1 {
+		t.Fatalf("expected a single put to succeed, got %d successes and %d failures", succeeded, failed)
+	}
+}
+
+func TestConcurrentMixedOperations(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	server := httptest.NewServer(NewHandler(db))
+	defer server.Close()
+
+	workers, operations := 8, 150
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < operations; i++ {
+				key := fmt.Sprintf(`"id": {"S": "h%d"}, "date": {"S": "d%d"}`, r.Intn(5), r.Intn(10))
+				var op, body string
+				switch r.Intn(9) {
+				case 0, 1:
+					op, body = "PutItem", `{"TableName": "bax", "Item": {`+key+`, "v": {"N": "1"}}}`
+				case 2:
+					op, body = "GetItem", `{"TableName": "bax", "Key": {`+key+`}}`
+				case 3:
+					op, body = "UpdateItem", `{"TableName": "bax", "Key": {`+key+`}, "AttributeUpdates": {"v": {"Action": "ADD", "Value": {"N": "1"}}}}`
+				case 4:
+					op, body = "DeleteItem", `{"TableName": "bax", "Key": {`+key+`}}`
+				case 5:
+					op, body = "Query", fmt.Sprintf(`{"TableName": "bax", "KeyConditions": {"id": {"ComparisonOperator": "EQ", "AttributeValueList": [{"S": "h%d"}]}}}`, r.Intn(5))
+				case 6:
+					op, body = "DescribeTable", `{"TableName": "bax"}`
+				case 7:
+					op, body = "ListTables", `{}`
+				case 8:
+					// Tables come and go while other requests are served
+					name := fmt.Sprintf("tmp%d", r.Intn(3))
+					op, body = "CreateTable", `{"TableName": "`+name+`", "AttributeDefinitions": [{"AttributeName": "id", "AttributeType": "S"}], "KeySchema": [{"AttributeName": "id", "KeyType": "HASH"}], "ProvisionedThroughput": {"ReadCapacityUnits": 1, "WriteCapacityUnits": 1}}`
+					if r.Intn(2) == 0 {
+						op, body = "DeleteTable", `{"TableName": "`+name+`"}`
+					}
+				}
+
+				status, response, err := DoOperation(server, op, body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				expectedError := strings.Contains(response, "ResourceInUseException") || strings.Contains(response, "ResourceNotFoundException")
+				if status != http.StatusOK && !(strings.HasSuffix(op, "Table") && expectedError) {
+					t.Errorf("%s %s: %d %s", op, body, status, response)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// The item count must match the items actually stored
+	table := db.GetTable("bax")
+	count := 0
+	for h := 0; h < 5; h++ {
+		result, err := table.Query(&QueryRequest{
+			KeyConditions: map[string]Condition{"id": Condition{ConditionOperator: EQ, AttributeValueList: []AttributeValue{AttributeValue{S: fmt.Sprintf("h%d", h)}}}},
+			TableName:     "bax",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(result.Items)
+	}
+	describe, _ := db.DescribeTable(&DescribeTableRequest{"bax"})
+	if describe.Table.ItemCount != float32(count) {
+		t.Fatalf("table has %d items but reports %v", count, describe.Table.ItemCount)
+	}
+}
+
+// DoOperation is like CallHandler but can be used from any goroutine. It
+// returns the status code and the response body.
+func DoOperation(server *httptest.Server, op, body string) (int, string, error) {
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	response, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(response), err
+}
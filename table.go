@@ -2,16 +2,26 @@ package dynamockdb
 
 import (
 	"fmt"
+	"sort"
 	// "strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Table is safe for concurrent use. Each exported operation holds the table
+// lock for its whole duration so that checking expectations and writing an
+// item happen atomically. The unexported variants expect the caller to hold
+// the lock.
+//
+// Stored items are never modified in place: writes store a new map, so an
+// item read under the lock can be used after it is released.
 type Table struct {
 	TableDescription TableDescription
-	Items            map[string]map[string]AttributeValue
-	InsertOrder      []string // Used for scanning
 	ConsumedCapacity ConsumedCapacity
+	mu               sync.RWMutex
+	partitions       *skipList  // Partitions by hash key, see index.go
+	items            *itemLocks // Items held by transactions, set by DB.CreateTable
 }
 
 func NewTable(req *CreateTableRequest) *Table {
@@ -32,45 +42,54 @@ func NewTable(req *CreateTableRequest) *Table {
 
 	return &Table{
 		TableDescription: desc,
-		Items:            make(map[string]map[string]AttributeValue),
-		InsertOrder:      make([]string, 0),
+		ConsumedCapacity: ConsumedCapacity{TableName: req.TableName},
+		partitions:       newSkipList(comparePartitionRefs),
 	}
 }
 
+// maxDecreasesPerDay is how many times a day the throughput of a table can
+// be decreased.
+const maxDecreasesPerDay = 4
+
 func (t *Table) UpdateTable(req *UpdateTableRequest) (*UpdateTableResult, error) {
-	decreased := false
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.updateTable(req)
+}
 
-	if t.TableDescription.ProvisionedThroughput.ReadCapacityUnits < req.ProvisionedThroughput.ReadCapacityUnits {
-		t.TableDescription.ProvisionedThroughput.LastIncreaseDateTime = time.Now()
-	} else {
-		decreased = true
-		t.TableDescription.ProvisionedThroughput.LastDecreaseDateTime = time.Now()
-	}
+func (t *Table) updateTable(req *UpdateTableRequest) (*UpdateTableResult, error) {
+	current := &t.TableDescription.ProvisionedThroughput
+	requested := req.ProvisionedThroughput
 
-	if t.TableDescription.ProvisionedThroughput.WriteCapacityUnits < req.ProvisionedThroughput.WriteCapacityUnits {
-		t.TableDescription.ProvisionedThroughput.LastIncreaseDateTime = time.Now()
-	} else {
-		decreased = true
-		t.TableDescription.ProvisionedThroughput.LastDecreaseDateTime = time.Now()
+	if requested.ReadCapacityUnits < 1 || requested.WriteCapacityUnits < 1 {
+		return nil, validationErrorf("One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be at least 1")
+	}
+	if requested.ReadCapacityUnits == current.ReadCapacityUnits && requested.WriteCapacityUnits == current.WriteCapacityUnits {
+		return nil, validationErrorf("The provisioned throughput for the table will not change. The requested value equals the current value. Current ReadCapacityUnits provisioned for the table: %v. Requested ReadCapacityUnits: %v. Current WriteCapacityUnits provisioned for the table: %v. Requested WriteCapacityUnits: %v.", current.ReadCapacityUnits, requested.ReadCapacityUnits, current.WriteCapacityUnits, requested.WriteCapacityUnits)
 	}
 
 	y, m, d := time.Now().Date()
 	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
-	var compTime time.Time
-	if t.TableDescription.ProvisionedThroughput.numberOfDecreasesDay == compTime {
-		t.TableDescription.ProvisionedThroughput.numberOfDecreasesDay = today
+	if current.numberOfDecreasesDay != today {
+		current.numberOfDecreasesDay = today
+		current.NumberOfDecreasesToday = 0
 	}
-	if t.TableDescription.ProvisionedThroughput.numberOfDecreasesDay != today {
-		t.TableDescription.ProvisionedThroughput.numberOfDecreasesDay = today
-		t.TableDescription.ProvisionedThroughput.NumberOfDecreasesToday = 0
+
+	decreased := requested.ReadCapacityUnits < current.ReadCapacityUnits || requested.WriteCapacityUnits < current.WriteCapacityUnits
+	if decreased && current.NumberOfDecreasesToday >= maxDecreasesPerDay {
+		return nil, &LimitExceededException{Message: fmt.Sprintf("Subscriber limit exceeded: Provisioned throughput decreases are limited within a given UTC day. After the most recent decrease, the table %s can be decreased %d times a day.", t.TableDescription.TableName, maxDecreasesPerDay)}
 	}
 
+	if current.ReadCapacityUnits < requested.ReadCapacityUnits || current.WriteCapacityUnits < requested.WriteCapacityUnits {
+		current.LastIncreaseDateTime = time.Now()
+	}
 	if decreased {
-		t.TableDescription.ProvisionedThroughput.NumberOfDecreasesToday += 1
+		current.LastDecreaseDateTime = time.Now()
+		current.NumberOfDecreasesToday += 1
 	}
 
-	t.TableDescription.ProvisionedThroughput.ReadCapacityUnits = req.ProvisionedThroughput.ReadCapacityUnits
-	t.TableDescription.ProvisionedThroughput.WriteCapacityUnits = req.ProvisionedThroughput.WriteCapacityUnits
+	current.ReadCapacityUnits = requested.ReadCapacityUnits
+	current.WriteCapacityUnits = requested.WriteCapacityUnits
 
 	result := &UpdateTableResult{
 		TableDescription: t.TableDescription,
@@ -79,6 +98,13 @@ func (t *Table) UpdateTable(req *UpdateTableRequest) (*UpdateTableResult, error)
 	return result, nil
 }
 
+// description returns a copy of the table description.
+func (t *Table) description() TableDescription {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.TableDescription
+}
+
 func (t *Table) HashKey() *AttributeDefinition {
 	for _, el := range t.TableDescription.KeySchema {
 		if el.KeyType == HashKeyType {
@@ -97,6 +123,16 @@ func (t *Table) RangeKey() *AttributeDefinition {
 	return nil
 }
 
+// isKeyAttribute tells if name is the hash or range key attribute.
+func (t *Table) isKeyAttribute(name string) bool {
+	for _, el := range t.TableDescription.KeySchema {
+		if el.AttributeName == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Table) GetAttribute(name string) *AttributeDefinition {
 	for _, def := range t.TableDescription.AttributeDefinitions {
 		if def.AttributeName == name {
@@ -107,54 +143,114 @@ func (t *Table) GetAttribute(name string) *AttributeDefinition {
 }
 
 func (t *Table) UpdateItem(req *UpdateItemRequest) (*UpdateItemResult, error) {
-	hashKey := t.HashKey()
-	key := ""
+	if err := t.checkTransactions(req.Key); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.updateItem(req)
+}
+
+func (t *Table) updateItem(req *UpdateItemRequest) (*UpdateItemResult, error) {
 	returnItem := make(map[string]AttributeValue)
 
-	if val, ok := req.Key[hashKey.AttributeName]; ok {
-		key = val.Value(hashKey.AttributeType)
-	} else {
-		return nil, fmt.Errorf("PuItem: Missing HashKey. %s", t.HashKey().AttributeName)
+	key, err := t.parseKey(req.Key)
+	if err != nil {
+		return nil, err
 	}
 
-	if t.Items[key] == nil {
-		return nil, fmt.Errorf("UpdateItem: Item not found")
-	} else {
-		if req.ReturnValues == AllOldReturnValues || req.ReturnValues == UpdatedOldReturnValues {
-			for k, v := range t.Items[key] {
-				returnItem[k] = v
-			}
+	if err := checkUpdateParameters(req); err != nil {
+		return nil, err
+	}
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	cond, err := parseWriteCondition(req.Expected, req.ConditionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	if req.UpdateExpression != "" {
+		if actions, err = t.parseUpdateExpression(req, attrs); err != nil {
+			return nil, err
+		}
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	// Updating an item that does not exist creates it
+	item := t.lookup(key)
+	if actions != nil {
+		if err := t.validateExpectations(nil, cond, item); err != nil {
+			return nil, err
+		}
+		return t.applyUpdateExpression(key, item, actions, req.ReturnValues)
+	}
+	if item != nil && (req.ReturnValues == AllOldReturnValues || req.ReturnValues == UpdatedOldReturnValues) {
+		for k, v := range item {
+			returnItem[k] = v
 		}
 	}
 
 	// Validate expections are met
-	err := t.validateExpectations(req.Expected, key)
+	err = t.validateExpectations(req.Expected, cond, item)
 	if err != nil {
 		return nil, err
 	}
 
+	// Work on a copy so a failing update leaves the item untouched
+	newItem := copyItem(item)
+	if item == nil {
+		newItem = key.attributes(t)
+	}
 	for k, v := range req.AttributeUpdates {
+		if t.isKeyAttribute(k) {
+			return nil, validationErrorf("Cannot update attribute %s. This attribute is part of the key", k)
+		}
+
 		switch v.Action {
 		case PutUpdateAction:
-			t.Items[key][k] = v.Value
+			val, err := v.Value.Normalize()
+			if err != nil {
+				return nil, err
+			}
+			newItem[k] = val
 		case DeleteUpdateAction:
-			delete(t.Items[key], k)
+			// Without a value the attribute is removed, otherwise the
+			// values are removed from the set
+			if v.Value.Type() == "" {
+				delete(newItem, k)
+				continue
+			}
+			old, ok := newItem[k]
+			if !ok {
+				continue
+			}
+			val, err := v.Value.DeleteFromSet(old)
+			if err != nil {
+				return nil, err
+			}
+			if val.Type() == "" {
+				delete(newItem, k)
+			} else {
+				newItem[k] = val
+			}
 		case AddUpdateAction:
-			attr := t.GetAttribute(k)
-			switch attr.AttributeType {
-			case NumberAttributeType:
-				if v.Value.Value(attr.AttributeType) == "" {
-					return nil, fmt.Errorf("UpdateItem: ADD to int field with non int value")
-				}
-				attrVal := t.Items[key][k]
-				attrVal.N = attrVal.N + v.Value.N
+			var old *AttributeValue
+			if val, ok := newItem[k]; ok {
+				old = &val
 			}
-
+			val, err := v.Value.AddTo(old)
+			if err != nil {
+				return nil, err
+			}
+			newItem[k] = val
 		}
 	}
+	t.store(key, newItem)
+	item = newItem
 
 	if req.ReturnValues == AllNewReturnValues || req.ReturnValues == UpdatedNewReturnValues {
-		returnItem = t.Items[key]
+		returnItem = copyItem(item)
 	}
 
 	if req.ReturnValues == UpdatedOldReturnValues || req.ReturnValues == UpdatedNewReturnValues {
@@ -172,47 +268,129 @@ func (t *Table) UpdateItem(req *UpdateItemRequest) (*UpdateItemResult, error) {
 	return result, nil
 }
 
-func (t *Table) PutItem(req *PutItemRequest) (*PutItemResult, error) {
+// checkUpdateParameters fails if req mixes the legacy parameters with
+// expressions.
+func checkUpdateParameters(req *UpdateItemRequest) error {
+	var legacy, expressions []string
+	if len(req.AttributeUpdates) > 0 {
+		legacy = append(legacy, "AttributeUpdates")
+	}
+	if len(req.Expected) > 0 {
+		legacy = append(legacy, "Expected")
+	}
+	if req.UpdateExpression != "" {
+		expressions = append(expressions, "UpdateExpression")
+	}
+	if req.ConditionExpression != "" {
+		expressions = append(expressions, "ConditionExpression")
+	}
+	if legacy != nil && expressions != nil {
+		return validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {%s} Expression parameters: {%s}", strings.Join(legacy, ", "), strings.Join(expressions, ", "))
+	}
+	return nil
+}
 
-	hashKey := t.HashKey()
+// parseUpdateExpression parses the UpdateExpression of req.
+func (t *Table) parseUpdateExpression(req *UpdateItemRequest, attrs expressionAttributes) ([]updateAction, error) {
+	actions, err := parseUpdate(req.UpdateExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		if t.isKeyAttribute(action.path[0].name) {
+			return nil, validationErrorf("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", action.path[0].name)
+		}
+	}
+	return actions, nil
+}
+
+// applyUpdateExpression updates the item at key, or creates it, and returns
+// the values asked for. UPDATED_OLD and UPDATED_NEW return the paths touched
+// by the actions.
+func (t *Table) applyUpdateExpression(key itemKey, item map[string]AttributeValue, actions []updateAction, returnValues ReturnValues) (*UpdateItemResult, error) {
+	old := item
+	if item == nil {
+		item = key.attributes(t)
+	}
+	newItem, err := applyUpdate(actions, item)
+	if err != nil {
+		return nil, err
+	}
+	t.store(key, newItem)
+
+	result := &UpdateItemResult{}
+	switch returnValues {
+	case AllOldReturnValues:
+		if old != nil {
+			result.Attributes = copyItem(old)
+		}
+	case AllNewReturnValues:
+		result.Attributes = copyItem(newItem)
+	case UpdatedOldReturnValues:
+		if old != nil {
+			result.Attributes = projectPaths(old, updatedPaths(actions))
+		}
+	case UpdatedNewReturnValues:
+		result.Attributes = projectPaths(newItem, updatedPaths(actions))
+	}
+	return result, nil
+}
+
+func (t *Table) PutItem(req *PutItemRequest) (*PutItemResult, error) {
+	if err := t.checkTransactions(req.Item); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.putItem(req)
+}
 
-	// Hash key value for item
-	key := ""
+func (t *Table) putItem(req *PutItemRequest) (*PutItemResult, error) {
 
 	// Item that will be returned at the end
 	returnItem := make(map[string]AttributeValue)
 
-	// Verify the new item contain the Hash key
-	if val, ok := req.Item[hashKey.AttributeName]; ok {
-		key = val.Value(hashKey.AttributeType)
-	} else {
-		return nil, fmt.Errorf("PuItem: Missing HashKey. %s", t.HashKey().AttributeName)
+	// Primary key value for item, the new item must contain all key attributes
+	key, err := t.keyOf(req.Item)
+	if err != nil {
+		return nil, err
 	}
 
-	// If no item there insert it
-	if t.Items[key] == nil {
-		t.Items[key] = make(map[string]AttributeValue)
-		t.InsertOrder = append(t.InsertOrder, key)
-	} else {
-		// Otherwise copy old values if needed in return item
+	item, err := normalizeItem(req.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	cond, err := parseWriteCondition(req.Expected, req.ConditionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	// Copy old values if needed in return item
+	oldItem := t.lookup(key)
+	if oldItem != nil {
 		if req.ReturnValues == AllOldReturnValues || req.ReturnValues == UpdatedOldReturnValues {
-			for k, v := range t.Items[key] {
+			for k, v := range oldItem {
 				returnItem[k] = v
 			}
 		}
 	}
 
-	err := t.validateExpectations(req.Expected, key)
+	err = t.validateExpectations(req.Expected, cond, oldItem)
 	if err != nil {
 		return nil, err
 	}
 
-	// Replace item
-	t.Items[key] = req.Item
+	// Insert or replace item
+	t.store(key, item)
 
 	// If return value new demanded replace returnItem
 	if req.ReturnValues == AllNewReturnValues || req.ReturnValues == UpdatedNewReturnValues {
-		returnItem = t.Items[key]
+		returnItem = copyItem(item)
 	}
 
 	// If updated values wanted replace returnItem again
@@ -232,34 +410,37 @@ func (t *Table) PutItem(req *PutItemRequest) (*PutItemResult, error) {
 }
 
 func (t *Table) DeleteItem(req *DeleteItemRequest) (*DeleteItemResult, error) {
-
-	if _, ok := req.Key[t.HashKey().AttributeName]; !ok {
-		return nil, fmt.Errorf("GetItem: Invalid key %v", req.Key)
+	if err := t.checkTransactions(req.Key); err != nil {
+		return nil, err
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.deleteItem(req)
+}
 
-	val := req.Key[t.HashKey().AttributeName]
-	key := val.Value(t.HashKey().AttributeType)
+func (t *Table) deleteItem(req *DeleteItemRequest) (*DeleteItemResult, error) {
 
-	if _, ok := t.Items[key]; !ok {
-		return nil, fmt.Errorf("GetItem: Not found for key '%v'", t.HashKey().AttributeName)
+	key, err := t.parseKey(req.Key)
+	if err != nil {
+		return nil, err
 	}
 
-	err := t.validateExpectations(req.Expected, key)
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	cond, err := parseWriteCondition(req.Expected, req.ConditionExpression, attrs)
 	if err != nil {
 		return nil, err
 	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
 
-	returnItem := t.Items[key]
-
-	delete(t.Items, key)
-
-	newInsertOrder := make([]string, 0, len(t.InsertOrder)-1)
-	for _, v := range t.InsertOrder {
-		if v == key {
-			newInsertOrder = append(newInsertOrder, v)
-		}
+	item := t.lookup(key)
+	err = t.validateExpectations(req.Expected, cond, item)
+	if err != nil {
+		return nil, err
 	}
-	t.InsertOrder = newInsertOrder
+
+	returnItem := t.remove(key)
 
 	result := &DeleteItemResult{
 		Attributes: returnItem,
@@ -268,14 +449,27 @@ func (t *Table) DeleteItem(req *DeleteItemRequest) (*DeleteItemResult, error) {
 	return result, nil
 }
 
-func (t *Table) validateExpectations(expected map[string]ExpectedAttributeValue, key string) error {
+// validateExpectations checks item meets the conditions of a write, given
+// as the legacy Expected map or as a parsed ConditionExpression, cond.
+func (t *Table) validateExpectations(expected map[string]ExpectedAttributeValue, cond condition, item map[string]AttributeValue) error {
+	if cond != nil && !cond.eval(item) {
+		return &ConditionalCheckFailedException{Message: "The conditional request failed"}
+	}
 	for field, exp := range expected {
-		val, exists := t.Items[key][field]
+		if exp.Exists && exp.Value.Type() == "" {
+			return validationErrorf("One or more parameter values were invalid: Exists is set to TRUE for attribute (%s), Value must also be set", field)
+		}
+		if !exp.Exists && exp.Value.Type() != "" {
+			return validationErrorf("One or more parameter values were invalid: Value cannot be used when Exists is set to FALSE for attribute (%s)", field)
+		}
+		val, exists := item[field]
 		if exists != exp.Exists {
-			return fmt.Errorf("PuItem: Expectation not met: %v", exp)
+			return &ConditionalCheckFailedException{Message: "The conditional request failed"}
+		}
+		if !exists {
+			continue
 		}
-		field := t.GetAttribute(field)
-		err := val.ValidateExpectations(field.AttributeType, exp)
+		err := val.ValidateExpectations(exp.Value.Type(), exp)
 		if err != nil {
 			return err
 		}
@@ -284,195 +478,527 @@ func (t *Table) validateExpectations(expected map[string]ExpectedAttributeValue,
 }
 
 func (t *Table) GetItem(req *GetItemRequest) (*GetItemResult, error) {
-	if _, ok := req.Key[t.HashKey().AttributeName]; !ok {
-		return nil, fmt.Errorf("GetItem: Invalid key %v", req.Key)
-	}
-
-	val := req.Key[t.HashKey().AttributeName]
-	key := val.Value(t.HashKey().AttributeType)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.getItem(req)
+}
 
-	if _, ok := t.Items[key]; !ok {
-		return nil, fmt.Errorf("GetItem: Not found for key '%v'", t.HashKey().AttributeName)
+func (t *Table) getItem(req *GetItemRequest) (*GetItemResult, error) {
+	key, err := t.parseKey(req.Key)
+	if err != nil {
+		return nil, err
 	}
-
-	item := t.Items[key]
-
-	returnItem := make(map[string]AttributeValue)
-	if len(req.AttributesToGet) > 0 {
-		for _, attr := range req.AttributesToGet {
-			if _, ok := item[attr]; ok {
-				returnItem[attr] = item[attr]
-			}
-		}
-	} else {
-		for attr := range item {
-			returnItem[attr] = item[attr]
-		}
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, nil)
+	paths, err := parseReadProjection(req.AttributesToGet, req.ProjectionExpression, attrs)
+	if err != nil {
+		return nil, err
 	}
-	result := &GetItemResult{
-		Item: returnItem,
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	// A missing item is not an error, the result just has no item
+	result := &GetItemResult{}
+	if item := t.lookup(key); item != nil {
+		result.Item = projectItem(item, paths)
 	}
 
 	if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
-		result.ConsumedCapacity = t.ConsumedCapacity
+		consumed := t.ConsumedCapacity
+		result.ConsumedCapacity = &consumed
 	}
 
 	return result, nil
 }
 
 func (t *Table) Query(req *QueryRequest) (*QueryResult, error) {
-	count := 0
-	items := make([]map[string]AttributeValue, 0, 20)
-	hashKey := t.HashKey()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.query(req)
+}
+
+func (t *Table) query(req *QueryRequest) (*QueryResult, error) {
+	if req.Limit < 0 {
+		return nil, validationErrorf("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", req.Limit)
+	}
+	if err := validateSelect(req.Select, req.AttributesToGet, req.ProjectionExpression, req.IndexName); err != nil {
+		return nil, err
+	}
+	index, indexKey, err := t.localIndex(req.IndexName)
+	if err != nil {
+		return nil, err
+	}
 	rangeKey := t.RangeKey()
+	if index != nil {
+		rangeKey = indexKey
+	}
+
+	attrs := newExpressionAttributes(req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	paths, err := parseReadProjection(req.AttributesToGet, req.ProjectionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := t.parseFilter(req.QueryFilter, req.ConditionalOperator, req.FilterExpression, attrs, true)
+	if err != nil {
+		return nil, err
+	}
+	keyConditions, err := t.keyConditions(req, attrs, rangeKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	hashKey := t.HashKey()
 	var hashCondition Condition
 	var rangeCondition Condition
-
-	for keyName, condition := range req.KeyConditions {
+	for keyName, condition := range keyConditions {
 		if keyName == hashKey.AttributeName {
 			hashCondition = condition
-		} else if keyName == rangeKey.AttributeName {
+		} else if rangeKey != nil && keyName == rangeKey.AttributeName {
 			rangeCondition = condition
 		} else {
-			return nil, fmt.Errorf("Query: Invalid KeyCondition, not on has or range fields. %v", keyName)
+			return nil, validationErrorf("Query condition missed key schema element: %s", keyName)
 		}
 	}
 
-	for _, key := range t.InsertOrder {
-		value := t.Items[key]
-		switch hashCondition.ConditionOperator {
-		case EQ:
-			if key == hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) {
-				items = append(items, value)
-			}
-		case LE:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) >= key {
-				items = append(items, value)
-			}
-		case LT:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) > key {
-				items = append(items, value)
-			}
-		case GE:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) <= key {
-				items = append(items, value)
-			}
-		case GT:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) < key {
-				items = append(items, value)
+	var start map[string]AttributeValue
+	if len(req.ExclusiveStartKey) > 0 {
+		if err := t.queryStartKey(req.ExclusiveStartKey, indexKey); err != nil {
+			return nil, err
+		}
+		start = req.ExclusiveStartKey
+	}
+
+	var project func(map[string]AttributeValue) map[string]AttributeValue
+	if req.Select != CountQuerySelect {
+		project = func(item map[string]AttributeValue) map[string]AttributeValue {
+			return t.queryItem(item, paths, req.Select, index, indexKey)
+		}
+	}
+	pg := &page{limit: req.Limit, filter: filter, project: project}
+
+	// The hash key condition is EQ, only one partition is read
+	hashVal, err := hashCondition.AttributeValueList[0].Normalize()
+	if err != nil {
+		return nil, err
+	}
+	hash := hashVal.Value(hashKey.AttributeType)
+	forward := req.ScanIndexForward == nil || *req.ScanIndexForward
+	if start != nil {
+		if key, _ := t.keyOf(start); key.hash != hash {
+			return nil, validationErrorf("The provided starting key is invalid: The provided hash key does not match the queried hash key")
+		}
+	}
+	if p := t.partition(hash); p != nil {
+		if index != nil {
+			t.walkIndexPartition(p, indexKey, rangeCondition, start, forward, pg.add)
+		} else {
+			t.walkPartition(p, rangeCondition, start, forward, pg.add)
+		}
+	}
+
+	result := &QueryResult{
+		ConsumedCapacity: pg.consumedCapacity(t, req.ReturnConsumedCapacity, req.ConsistentRead),
+		Count:            pg.count,
+		Items:            pg.items,
+		ScannedCount:     pg.scanned,
+	}
+	if pg.last != nil {
+		result.LastEvaluatedKey = t.queryKey(pg.last, indexKey)
+	}
+	return result, nil
+}
+
+// keyConditions returns the key conditions of req, given either with the
+// legacy KeyConditions map or as a KeyConditionExpression. Both follow the
+// rules of DynamoDB: an EQ condition on the hash key and at most one
+// condition on the range key, with an operator that can walk an index.
+func (t *Table) keyConditions(req *QueryRequest, attrs expressionAttributes, rangeKey *AttributeDefinition) (map[string]Condition, error) {
+	conditions := req.KeyConditions
+	expression := req.KeyConditionExpression != ""
+	if expression {
+		if len(req.KeyConditions) > 0 {
+			return nil, validationErrorf("Can not use both expression and non-expression parameters in the same request: Non-expression parameters: {KeyConditions} Expression parameters: {KeyConditionExpression}")
+		}
+		var err error
+		if conditions, err = parseKeyCondition(req.KeyConditionExpression, attrs); err != nil {
+			return nil, err
+		}
+	}
+	if len(conditions) > 2 {
+		return nil, validationErrorf("Conditions can be of length 1 or 2 only")
+	}
+
+	hashKey := t.HashKey()
+	hash, ok := conditions[hashKey.AttributeName]
+	if !ok {
+		return nil, validationErrorf("Query condition missed key schema element: %s", hashKey.AttributeName)
+	}
+	if hash.ConditionOperator != EQ {
+		return nil, validationErrorf("Query key condition not supported")
+	}
+	for name, cond := range conditions {
+		var attributeType AttributeType
+		switch {
+		case name == hashKey.AttributeName:
+			attributeType = hashKey.AttributeType
+		case rangeKey != nil && name == rangeKey.AttributeName:
+			attributeType = rangeKey.AttributeType
+		default:
+			return nil, validationErrorf("Query condition missed key schema element: %s", name)
+		}
+
+		arguments := 1
+		switch cond.ConditionOperator {
+		case EQ, LE, LT, GE, GT, BEGINS_WITH:
+		case BETWEEN:
+			arguments = 2
+		default:
+			return nil, validationErrorf("Attempted conditional constraint is not an indexable operation")
+		}
+		if len(cond.AttributeValueList) != arguments {
+			return nil, validationErrorf("One or more parameter values were invalid: Invalid number of argument(s) for the %s ComparisonOperator", cond.ConditionOperator)
+		}
+		if cond.ConditionOperator == BEGINS_WITH && attributeType == NumberAttributeType {
+			if expression {
+				return nil, validationErrorf("Invalid KeyConditionExpression: Incorrect operand type for operator or function; operator or function: begins_with, operand type: N")
 			}
-		case NE:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) != key {
-				items = append(items, value)
+			return nil, validationErrorf("One or more parameter values were invalid: ComparisonOperator BEGINS_WITH is not valid for N AttributeValue type")
+		}
+		for _, v := range cond.AttributeValueList {
+			if v.Type() != attributeType {
+				return nil, validationErrorf("One or more parameter values were invalid: Condition parameter type does not match schema type")
 			}
-		case IN:
-			for _, attrib := range hashCondition.AttributeValueList {
-				if attrib.Value(hashKey.AttributeType) == key {
-					items = append(items, value)
-					break
-				}
+		}
+	}
+	return conditions, nil
+}
+
+// walkPartition calls fn with the items of p which range key matches cond,
+// in range key order or in reverse, until fn returns false. The walk resumes
+// right after the start item when start is set, even if it has been deleted
+// since, and only covers the span of the partition that can match.
+func (t *Table) walkPartition(p *partition, cond Condition, start map[string]AttributeValue, forward bool, fn func(map[string]AttributeValue) bool) {
+	rangeKey := t.RangeKey()
+	if rangeKey == nil {
+		// A single item, the start item itself if there is one
+		if n := p.Items.First(); n != nil && start == nil {
+			fn(n.Value.(map[string]AttributeValue))
+		}
+		return
+	}
+
+	var after AttributeValue
+	if start != nil {
+		key, _ := t.keyOf(start)
+		after = key.rangeKey
+	}
+
+	var n *skipNode
+	if forward {
+		n = p.Items.First()
+		switch cond.ConditionOperator {
+		case EQ, GE, GT, BETWEEN, BEGINS_WITH:
+			n = p.Items.Seek(cond.AttributeValueList[0])
+		}
+		if start != nil && n != nil && t.compareRangeKeys(n.Key, after) <= 0 {
+			n = p.Items.Seek(after)
+			if n != nil && t.compareRangeKeys(n.Key, after) == 0 {
+				n = n.Next()
 			}
+		}
+	} else {
+		n = p.Items.Last()
+		switch cond.ConditionOperator {
+		case EQ, LE:
+			n = seekAtMost(p.Items, cond.AttributeValueList[0])
+		case LT:
+			n = seekBefore(p.Items, cond.AttributeValueList[0])
+		case BETWEEN:
+			n = seekAtMost(p.Items, cond.AttributeValueList[1])
 		case BEGINS_WITH:
-			if strings.HasPrefix(key, hashCondition.AttributeValueList[0].Value(hashKey.AttributeType)) {
-				items = append(items, value)
+			if end, ok := prefixEnd(cond.AttributeValueList[0], rangeKey.AttributeType); ok {
+				n = seekBefore(p.Items, end)
 			}
-		case BETWEEN:
-			if hashCondition.AttributeValueList[0].Value(hashKey.AttributeType) <= key && hashCondition.AttributeValueList[1].Value(hashKey.AttributeType) >= key {
-				items = append(items, value)
+		}
+		if start != nil && n != nil && t.compareRangeKeys(n.Key, after) >= 0 {
+			n = seekBefore(p.Items, after)
+		}
+	}
+
+	for n != nil {
+		v := n.Key.(AttributeValue)
+		if forward && pastKeyCondition(cond, v, rangeKey.AttributeType) || !forward && beforeKeyCondition(cond, v, rangeKey.AttributeType) {
+			return
+		}
+		if cond.ConditionOperator == "" || matchKeyCondition(cond, v, rangeKey.AttributeType) {
+			if !fn(n.Value.(map[string]AttributeValue)) {
+				return
 			}
 		}
+		if forward {
+			n = n.Next()
+		} else {
+			n = n.Prev()
+		}
 	}
+}
 
-	newItems := make([]map[string]AttributeValue, 0, len(items))
-	if rangeKey != nil {
-		for _, value := range items {
-			fmt.Println("value", value, rangeKey.AttributeName)
-			v1 := value[rangeKey.AttributeName]
-			v2 := rangeCondition.AttributeValueList[0]
-			switch rangeCondition.ConditionOperator {
-			case EQ:
-				if v1.Value(rangeKey.AttributeType) == v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case LE:
-				if v1.Value(rangeKey.AttributeType) <= v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case LT:
-				if v1.Value(rangeKey.AttributeType) < v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case GE:
-				if v1.Value(rangeKey.AttributeType) >= v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case GT:
-				if v1.Value(rangeKey.AttributeType) > v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case NE:
-				if v1.Value(rangeKey.AttributeType) != v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
-				}
-			case IN:
-				for _, attrib := range rangeCondition.AttributeValueList {
-					if attrib.Value(rangeKey.AttributeType) == v1.Value(rangeKey.AttributeType) {
-						items = append(items, value)
-						break
-					}
-				}
-			case BEGINS_WITH:
-				if strings.HasPrefix(v1.Value(rangeKey.AttributeType), v2.Value(rangeKey.AttributeType)) {
-					newItems = append(newItems, value)
-				}
-			case BETWEEN:
-				if v1.Value(rangeKey.AttributeType) >= v2.Value(rangeKey.AttributeType) && v1.Value(rangeKey.AttributeType) <= v2.Value(rangeKey.AttributeType) {
-					newItems = append(newItems, value)
+// seekBefore returns the last node of l which key is lower than key, or nil.
+func seekBefore(l *skipList, key interface{}) *skipNode {
+	if n := l.Seek(key); n != nil {
+		return n.Prev()
+	}
+	return l.Last()
+}
+
+// seekAtMost returns the last node of l which key is lower or equal to key,
+// or nil.
+func seekAtMost(l *skipList, key interface{}) *skipNode {
+	if n := l.Seek(key); n != nil && l.compare(n.Key, key) == 0 {
+		return n
+	}
+	return seekBefore(l, key)
+}
+
+// walkIndexPartition calls fn with the items of p which have the local index
+// range key indexKey and match cond, in index order or in reverse, until fn
+// returns false. The walk resumes right after the start item when start is
+// set. Items are not stored in index order, the matching ones are sorted
+// first.
+func (t *Table) walkIndexPartition(p *partition, indexKey *AttributeDefinition, cond Condition, start map[string]AttributeValue, forward bool, fn func(map[string]AttributeValue) bool) {
+	var items []map[string]AttributeValue
+	for n := p.Items.First(); n != nil; n = n.Next() {
+		item := n.Value.(map[string]AttributeValue)
+		v, ok := item[indexKey.AttributeName]
+		if !ok {
+			continue
+		}
+		if cond.ConditionOperator == "" || matchKeyCondition(cond, v, indexKey.AttributeType) {
+			items = append(items, item)
+		}
+	}
+	// Items with the same index key stay in range key order
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareValues(items[i][indexKey.AttributeName], items[j][indexKey.AttributeName], indexKey.AttributeType) < 0
+	})
+
+	if forward {
+		i := 0
+		if start != nil {
+			i = sort.Search(len(items), func(i int) bool { return t.compareQueryOrder(items[i], start, indexKey) > 0 })
+		}
+		for ; i < len(items) && fn(items[i]); i++ {
+		}
+	} else {
+		i := len(items) - 1
+		if start != nil {
+			i = sort.Search(len(items), func(i int) bool { return t.compareQueryOrder(items[i], start, indexKey) >= 0 }) - 1
+		}
+		for ; i >= 0 && fn(items[i]); i-- {
+		}
+	}
+}
+
+// localIndex returns the local secondary index name and its range key, or
+// nils if name is empty.
+func (t *Table) localIndex(name string) (*LocalSecondaryIndex, *AttributeDefinition, error) {
+	if name == "" {
+		return nil, nil, nil
+	}
+	for i, index := range t.TableDescription.LocalSecondaryIndexes {
+		if index.IndexName != name {
+			continue
+		}
+		for _, el := range index.KeySchema {
+			if el.KeyType == RangeKeyType {
+				if key := t.GetAttribute(el.AttributeName); key != nil {
+					return &t.TableDescription.LocalSecondaryIndexes[i], key, nil
 				}
 			}
 		}
-		items = newItems
 	}
+	return nil, nil, validationErrorf("The table does not have the specified index: %s", name)
+}
 
-	if len(req.ExclusiveStartKey) > 0 {
-		newItems = make([]map[string]AttributeValue, 0)
-		a := req.ExclusiveStartKey[hashKey.AttributeName]
-		startKey := a.Value(hashKey.AttributeType)
-		startKeySeen := false
-		for _, item := range items {
-			if startKeySeen {
-				newItems = append(newItems, item)
-			} else {
-				a = item[hashKey.AttributeName]
-				if a.Value(hashKey.AttributeType) == startKey {
-					startKeySeen = true
-				}
+// compareQueryOrder orders the items read by a Query going forward: by
+// partition, then by index range key when indexKey is set, then by range key.
+func (t *Table) compareQueryOrder(a, b map[string]AttributeValue, indexKey *AttributeDefinition) int {
+	ka, _ := t.keyOf(a)
+	kb, _ := t.keyOf(b)
+	if cmp := comparePartitionRefs(newPartitionRef(ka.hash), newPartitionRef(kb.hash)); cmp != 0 {
+		return cmp
+	}
+	if indexKey != nil {
+		if cmp := compareValues(a[indexKey.AttributeName], b[indexKey.AttributeName], indexKey.AttributeType); cmp != 0 {
+			return cmp
+		}
+	}
+	return t.compareRangeKeys(ka.rangeKey, kb.rangeKey)
+}
+
+// queryKey returns the key of item a Query pages with, its primary key and
+// its index range key when indexKey is set.
+func (t *Table) queryKey(item map[string]AttributeValue, indexKey *AttributeDefinition) map[string]AttributeValue {
+	key, _ := t.keyOf(item)
+	attrs := key.attributes(t)
+	if indexKey != nil {
+		attrs[indexKey.AttributeName] = item[indexKey.AttributeName]
+	}
+	return attrs
+}
+
+// queryStartKey checks the ExclusiveStartKey of a Query holds the primary
+// key, and the index range key when indexKey is set.
+func (t *Table) queryStartKey(attrs map[string]AttributeValue, indexKey *AttributeDefinition) error {
+	if indexKey == nil || t.isKeyAttribute(indexKey.AttributeName) {
+		_, err := t.exclusiveStartKey(attrs)
+		return err
+	}
+	if _, ok := attrs[indexKey.AttributeName]; !ok {
+		return validationErrorf("The provided starting key is invalid: The provided key element does not match the schema")
+	}
+	primary := copyItem(attrs)
+	delete(primary, indexKey.AttributeName)
+	_, err := t.exclusiveStartKey(primary)
+	return err
+}
+
+// queryItem returns what a Query returns of item. Reading an index returns
+// the attributes it projects, unless paths or all attributes are selected.
+func (t *Table) queryItem(item map[string]AttributeValue, paths []documentPath, sel QuerySelect, index *LocalSecondaryIndex, indexKey *AttributeDefinition) map[string]AttributeValue {
+	if len(paths) > 0 || index == nil || sel == AllAttributesQuerySelect || index.Projection.ProjectionType == AllProjectionType {
+		return projectItem(item, paths)
+	}
+	projected := t.queryKey(item, indexKey)
+	if index.Projection.ProjectionType == IncludeProjectionType {
+		for _, name := range index.Projection.NonKeyAttributes {
+			if v, ok := item[name]; ok {
+				projected[name] = v
 			}
 		}
-		items = newItems
-	}
-
-	// returnItem := make(map[string]AttributeValue)
-	// if len(req.AttributesToGet) > 0 {
-	// 	for _, attr := range req.AttributesToGet {
-	// 		if _, ok := item[attr]; ok {
-	// 			returnItem[attr] = item[attr]
-	// 		}
-	// 	}
-	// } else {
-	// 	for attr := range item {
-	// 		returnItem[attr] = item[attr]
-	// 	}
-	// }
-	result := &QueryResult{
-		// Item: returnItem,
-		Items: items,
-		Count: count,
 	}
+	return projected
+}
 
-	if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
-		result.ConsumedCapacity = t.ConsumedCapacity
+// projectItem returns a copy of item with only the parts at paths, or all
+// of it if paths is empty.
+func projectItem(item map[string]AttributeValue, paths []documentPath) map[string]AttributeValue {
+	if len(paths) == 0 {
+		return copyItem(item)
 	}
+	return projectPaths(item, paths)
+}
 
-	return result, nil
+// validateSelect checks Select is consistent with AttributesToGet or
+// ProjectionExpression and the index being read.
+func validateSelect(sel QuerySelect, attributesToGet []string, projectionExpression string, indexName string) error {
+	projection := ""
+	switch {
+	case len(attributesToGet) > 0:
+		projection = "AttributesToGet"
+	case projectionExpression != "":
+		projection = "ProjectionExpression"
+	}
+
+	switch sel {
+	case "":
+	case AllAttributesQuerySelect:
+		if projection != "" {
+			return validationErrorf("Cannot specify the %s when choosing to get ALL_ATTRIBUTES", projection)
+		}
+	case CountQuerySelect:
+		if projection != "" {
+			return validationErrorf("Cannot specify the %s when choosing to get only the COUNT", projection)
+		}
+	case AllProjectedAttributesQuerySelect:
+		if indexName == "" {
+			return validationErrorf("ALL_PROJECTED_ATTRIBUTES can be used only when Querying using an IndexName")
+		}
+		if projection != "" {
+			return validationErrorf("Cannot specify the %s when choosing to get ALL_PROJECTED_ATTRIBUTES", projection)
+		}
+	case SpecificAttributesAttributesQuerySelect:
+		if projection == "" {
+			return validationErrorf("SPECIFIC_ATTRIBUTES must be used with AttributesToGet or ProjectionExpression")
+		}
+	default:
+		return validationErrorf("1 validation error detected: Value '%s' at 'select' failed to satisfy constraint: Member must satisfy enum value set: [SPECIFIC_ATTRIBUTES, COUNT, ALL_ATTRIBUTES, ALL_PROJECTED_ATTRIBUTES]", sel)
+	}
+	return nil
+}
+
+// matchKeyCondition tells if the key attribute v matches cond.
+func matchKeyCondition(cond Condition, v AttributeValue, attributeType AttributeType) bool {
+	if len(cond.AttributeValueList) == 0 {
+		return false
+	}
+	cmp := compareValues(v, cond.AttributeValueList[0], attributeType)
+	switch cond.ConditionOperator {
+	case EQ:
+		return cmp == 0
+	case NE:
+		return cmp != 0
+	case LE:
+		return cmp <= 0
+	case LT:
+		return cmp < 0
+	case GE:
+		return cmp >= 0
+	case GT:
+		return cmp > 0
+	case IN:
+		for _, attrib := range cond.AttributeValueList {
+			if compareValues(v, attrib, attributeType) == 0 {
+				return true
+			}
+		}
+	case BEGINS_WITH:
+		return v.HasPrefix(&cond.AttributeValueList[0], attributeType)
+	case BETWEEN:
+		return len(cond.AttributeValueList) == 2 && cmp >= 0 && compareValues(v, cond.AttributeValueList[1], attributeType) <= 0
+	}
+	return false
+}
+
+// pastKeyCondition tells if, walking keys in ascending order, v and all keys
+// after it can no longer match cond.
+func pastKeyCondition(cond Condition, v AttributeValue, attributeType AttributeType) bool {
+	switch cond.ConditionOperator {
+	case EQ, LE:
+		return compareValues(v, cond.AttributeValueList[0], attributeType) > 0
+	case LT:
+		return compareValues(v, cond.AttributeValueList[0], attributeType) >= 0
+	case BETWEEN:
+		return len(cond.AttributeValueList) == 2 && compareValues(v, cond.AttributeValueList[1], attributeType) > 0
+	case BEGINS_WITH:
+		return !v.HasPrefix(&cond.AttributeValueList[0], attributeType)
+	}
+	return false
+}
+
+// beforeKeyCondition tells if, walking keys in descending order, v and all
+// keys after it can no longer match cond.
+func beforeKeyCondition(cond Condition, v AttributeValue, attributeType AttributeType) bool {
+	switch cond.ConditionOperator {
+	case EQ, GE, BETWEEN, BEGINS_WITH:
+		return compareValues(v, cond.AttributeValueList[0], attributeType) < 0
+	case GT:
+		return compareValues(v, cond.AttributeValueList[0], attributeType) <= 0
+	}
+	return false
+}
+
+// prefixEnd returns the lowest string or binary value greater than all the
+// values starting with prefix, or false if there is none.
+func prefixEnd(prefix AttributeValue, attributeType AttributeType) (AttributeValue, bool) {
+	b := []byte(prefix.Value(attributeType))
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			return scalarValue(string(append(b[:i:i], b[i]+1)), attributeType), true
+		}
+	}
+	return AttributeValue{}, false
 }
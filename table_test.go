@@ -1,6 +1,8 @@
 package dynamockdb
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -133,6 +135,185 @@ func TestUpdateItem(t *testing.T) {
 	}
 }
 
+func TestUpdateItemAdd(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "count": AttributeValue{N: "9"}, "tags": AttributeValue{NS: []string{"1", "2"}}})
+
+	req := &UpdateItemRequest{
+		Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{
+			"count": AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{N: "1.50"}},
+			"tags":  AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{NS: []string{"2.0", "3"}}},
+			"new":   AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{N: "-5"}},
+		},
+		TableName:    "bax",
+		ReturnValues: AllNewReturnValues,
+	}
+
+	result, err := table.UpdateItem(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Attributes["count"].N != "10.5" {
+		t.Fatalf("wrong count %+v", result.Attributes["count"])
+	}
+	if result.Attributes["new"].N != "-5" {
+		t.Fatalf("wrong new %+v", result.Attributes["new"])
+	}
+	if !equalSets(result.Attributes["tags"].NS, []string{"1", "2", "3"}, NumberAttributeType) {
+		t.Fatalf("wrong tags %+v", result.Attributes["tags"])
+	}
+
+	// Delete from set
+
+	req = &UpdateItemRequest{
+		Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{
+			"tags": AttributeValueUpdate{Action: DeleteUpdateAction, Value: AttributeValue{NS: []string{"1.0", "3"}}},
+		},
+		TableName:    "bax",
+		ReturnValues: AllNewReturnValues,
+	}
+
+	result, err = table.UpdateItem(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalSets(result.Attributes["tags"].NS, []string{"2"}, NumberAttributeType) {
+		t.Fatalf("wrong tags %+v", result.Attributes["tags"])
+	}
+
+	// Overflow is rejected and leaves the item untouched
+
+	req = &UpdateItemRequest{
+		Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{
+			"count": AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{N: "9.9999999999999999999999999999999999999E+125"}},
+		},
+		TableName: "bax",
+	}
+
+	if _, err = table.UpdateItem(req); err == nil {
+		t.Fatalf("overflow should be rejected")
+	}
+
+	getResult, _ := table.GetItem(&GetItemRequest{Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}, TableName: "bax"})
+	if getResult.Item["count"].N != "10.5" {
+		t.Fatalf("wrong count %+v", getResult.Item["count"])
+	}
+
+	// Malformed numbers are rejected
+
+	req.AttributeUpdates["count"] = AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{N: "1..5"}}
+	if _, err = table.UpdateItem(req); err == nil {
+		t.Fatalf("malformed number should be rejected")
+	}
+}
+
+func TestDocumentItems(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+
+	yes := true
+	doc := AttributeValue{M: map[string]AttributeValue{
+		"name":    AttributeValue{S: "bar"},
+		"active":  AttributeValue{BOOL: &yes},
+		"missing": AttributeValue{NULL: true},
+		"scores":  AttributeValue{L: []AttributeValue{AttributeValue{N: "1.0"}, AttributeValue{M: map[string]AttributeValue{}}}},
+	}}
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}, "doc": doc})
+
+	result, err := table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := result.Item["doc"]
+	if !got.Equal(&doc) {
+		t.Fatalf("expected %+v, got %+v", doc, got)
+	}
+	if got.M["scores"].L[0].N != "1" {
+		t.Fatalf("nested number not normalized %+v", got.M["scores"])
+	}
+	if *got.M["active"].BOOL != true || !got.M["missing"].NULL {
+		t.Fatalf("wrong values %+v", got)
+	}
+
+	// Query returns documents too
+
+	queryResult, err := table.Query(&QueryRequest{
+		KeyConditions: map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}},
+		TableName:     "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = queryResult.Items[0]["doc"]
+	if len(queryResult.Items) != 1 || !got.Equal(&doc) {
+		t.Fatalf("wrong items %+v", queryResult.Items)
+	}
+
+	// Updates and expectations on documents
+
+	no := false
+	_, err = table.UpdateItem(&UpdateItemRequest{
+		Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{
+			"flag": AttributeValueUpdate{Action: PutUpdateAction, Value: AttributeValue{BOOL: &no}},
+			"list": AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{L: []AttributeValue{AttributeValue{S: "a"}}}},
+		},
+		Expected:  map[string]ExpectedAttributeValue{"doc": ExpectedAttributeValue{Exists: true, Value: doc}},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updateResult, err := table.UpdateItem(&UpdateItemRequest{
+		Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{
+			"list": AttributeValueUpdate{Action: AddUpdateAction, Value: AttributeValue{L: []AttributeValue{AttributeValue{S: "b"}}}},
+		},
+		TableName:    "bax",
+		ReturnValues: AllNewReturnValues,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateResult.Attributes["flag"].BOOL != false {
+		t.Fatalf("wrong flag %+v", updateResult.Attributes["flag"])
+	}
+	if list := updateResult.Attributes["list"].L; len(list) != 2 || list[0].S != "a" || list[1].S != "b" {
+		t.Fatalf("wrong list %+v", updateResult.Attributes["list"])
+	}
+
+	// Nesting limit
+
+	deep := AttributeValue{S: "deep"}
+	for i := 0; i < 33; i++ {
+		deep = AttributeValue{L: []AttributeValue{deep}}
+	}
+	_, err = table.PutItem(&PutItemRequest{
+		Item:      map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}, "deep": deep},
+		TableName: "bax",
+	})
+	if err == nil {
+		t.Fatalf("too deep value should be rejected")
+	}
+	_, err = table.PutItem(&PutItemRequest{
+		Item:      map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}, "deep": deep.L[0]},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetItem(t *testing.T) {
 	db := NewDB()
 	CreateTable(db, "bax")
@@ -222,9 +403,12 @@ func TestDeleteItem(t *testing.T) {
 		AttributesToGet: []string{"foo"},
 	}
 
-	_, err := table.GetItem(reqB)
-	if err == nil {
-		t.Fatalf("err should be nil")
+	deleted, err := table.GetItem(reqB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Item != nil {
+		t.Fatalf("deleted item still there %+v", deleted.Item)
 	}
 
 	// Other still there
@@ -261,11 +445,336 @@ func TestDeleteItem(t *testing.T) {
 		Key: map[string]AttributeValue{"id": AttributeValue{S: "nothing"}},
 	}
 
-	_, err = table.DeleteItem(req)
-	if err == nil {
-		t.Fail()
+	result, err = table.DeleteItem(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Attributes) != 0 {
+		t.Fatalf("nothing should be returned, got %+v", result.Attributes)
+	}
+
+}
+
+func TestConditionExpression(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "count": AttributeValue{N: "1"}})
+	key := map[string]AttributeValue{"id": AttributeValue{S: "bar"}}
+
+	// Put only if the item does not exist
+
+	put := &PutItemRequest{
+		Item:                map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "count": AttributeValue{N: "5"}},
+		TableName:           "bax",
+		ConditionExpression: "attribute_not_exists(id)",
+	}
+	_, err := table.PutItem(put)
+	if err == nil || !strings.HasPrefix(err.Error(), "ConditionalCheckFailedException") {
+		t.Fatalf("expected ConditionalCheckFailedException, got %v", err)
+	}
+	put.ConditionExpression = "#c BETWEEN :low AND :high"
+	put.ExpressionAttributeNames = map[string]string{"#c": "count"}
+	put.ExpressionAttributeValues = map[string]AttributeValue{":low": AttributeValue{N: "0"}, ":high": AttributeValue{N: "2"}}
+	if _, err := table.PutItem(put); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &UpdateItemRequest{
+		Key:                       key,
+		UpdateExpression:          "SET foo = :bam",
+		TableName:                 "bax",
+		ConditionExpression:       "#c IN (:one, :two)",
+		ExpressionAttributeNames:  map[string]string{"#c": "count"},
+		ExpressionAttributeValues: map[string]AttributeValue{":one": AttributeValue{N: "1"}, ":two": AttributeValue{N: "2"}, ":bam": AttributeValue{S: "bam"}},
+		ReturnValues:              AllNewReturnValues,
+	}
+	_, err = table.UpdateItem(update)
+	if err == nil || !strings.HasPrefix(err.Error(), "ConditionalCheckFailedException") {
+		t.Fatalf("expected ConditionalCheckFailedException, got %v", err)
+	}
+	update.ExpressionAttributeValues[":two"] = AttributeValue{N: "5"}
+	if result, err := table.UpdateItem(update); err != nil || result.Attributes["foo"].S != "bam" {
+		t.Fatalf("UpdateItem failed: %+v %v", result, err)
+	}
+
+	remove := &DeleteItemRequest{
+		Key:                       key,
+		TableName:                 "bax",
+		ConditionExpression:       "begins_with(foo, :prefix) AND NOT contains(foo, :prefix)",
+		ExpressionAttributeValues: map[string]AttributeValue{":prefix": AttributeValue{S: "ba"}},
+	}
+	_, err = table.DeleteItem(remove)
+	if err == nil || !strings.HasPrefix(err.Error(), "ConditionalCheckFailedException") {
+		t.Fatalf("expected ConditionalCheckFailedException, got %v", err)
+	}
+	remove.ConditionExpression = "begins_with(foo, :prefix) AND size(foo) = :three"
+	remove.ExpressionAttributeValues[":three"] = AttributeValue{N: "3"}
+	if result, err := table.DeleteItem(remove); err != nil || result.Attributes["foo"].S != "bam" {
+		t.Fatalf("DeleteItem failed: %+v %v", result, err)
+	}
+
+	// Invalid conditions
+
+	invalid := map[string]*PutItemRequest{
+		"Syntax error; token: \"=\", near: \"= =\"": &PutItemRequest{ConditionExpression: "id = = :v", ExpressionAttributeValues: map[string]AttributeValue{":v": AttributeValue{S: "v"}}},
+		"unused in expressions: keys: {:w}":         &PutItemRequest{ConditionExpression: "id = :v", ExpressionAttributeValues: map[string]AttributeValue{":v": AttributeValue{S: "v"}, ":w": AttributeValue{S: "w"}}},
+		"Can not use both expression and non-expression parameters": &PutItemRequest{
+			ConditionExpression: "attribute_exists(id)",
+			Expected:            map[string]ExpectedAttributeValue{"foo": ExpectedAttributeValue{Exists: false}},
+		},
+	}
+	for message, req := range invalid {
+		req.Item, req.TableName = key, "bax"
+		_, err := table.PutItem(req)
+		if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") || !strings.Contains(err.Error(), message) {
+			t.Fatalf("expected ValidationException with %q, got %v", message, err)
+		}
+	}
+}
+
+func TestUpdateExpression(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{
+		"id":   AttributeValue{S: "bar"},
+		"hits": AttributeValue{N: "1"},
+		"tags": AttributeValue{SS: []string{"a", "b"}},
+		"doc": AttributeValue{M: map[string]AttributeValue{
+			"entries": AttributeValue{L: []AttributeValue{AttributeValue{S: "x"}, AttributeValue{S: "y"}, AttributeValue{S: "z"}}},
+			"name":    AttributeValue{S: "old"},
+		}},
+	})
+	key := map[string]AttributeValue{"id": AttributeValue{S: "bar"}}
+
+	result, err := table.UpdateItem(&UpdateItemRequest{
+		Key:                      key,
+		TableName:                "bax",
+		UpdateExpression:         "SET hits = hits + :one, doc.#n = :new, doc.entries = list_append(doc.entries, :more), seen = if_not_exists(seen, :one) ADD tags :c DELETE extra :a",
+		ExpressionAttributeNames: map[string]string{"#n": "name"},
+		ExpressionAttributeValues: map[string]AttributeValue{
+			":one":  AttributeValue{N: "1"},
+			":new":  AttributeValue{S: "new"},
+			":more": AttributeValue{L: []AttributeValue{AttributeValue{S: "w"}}},
+			":c":    AttributeValue{SS: []string{"c"}},
+			":a":    AttributeValue{SS: []string{"a"}},
+		},
+		ReturnValues: UpdatedNewReturnValues,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := result.Attributes
+	if len(attrs) != 4 || attrs["hits"].N != "2" || attrs["seen"].N != "1" || len(attrs["tags"].SS) != 3 {
+		t.Fatalf("wrong updated values %+v", attrs)
+	}
+	if doc := attrs["doc"].M; len(doc) != 2 || doc["name"].S != "new" || len(doc["entries"].L) != 4 {
+		t.Fatalf("wrong updated document %+v", attrs["doc"])
+	}
+
+	// Removing list elements by their index before the update
+
+	result, err = table.UpdateItem(&UpdateItemRequest{
+		Key:                       key,
+		TableName:                 "bax",
+		UpdateExpression:          "REMOVE doc.entries[0], doc.entries[2] SET hits = hits - :two DELETE tags :a",
+		ExpressionAttributeValues: map[string]AttributeValue{":two": AttributeValue{N: "2"}, ":a": AttributeValue{SS: []string{"a"}}},
+		ReturnValues:              UpdatedOldReturnValues,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs = result.Attributes
+	if entries := attrs["doc"].M["entries"].L; len(entries) != 2 || entries[0].S != "x" || entries[1].S != "z" || attrs["hits"].N != "2" {
+		t.Fatalf("wrong updated old values %+v", attrs)
+	}
+	get, _ := table.GetItem(&GetItemRequest{Key: key, TableName: "bax"})
+	if entries := get.Item["doc"].M["entries"].L; len(entries) != 2 || entries[0].S != "y" || entries[1].S != "w" || get.Item["hits"].N != "0" || len(get.Item["tags"].SS) != 2 {
+		t.Fatalf("wrong item %+v", get.Item)
+	}
+
+	// Updating a missing item creates it
+
+	result, err = table.UpdateItem(&UpdateItemRequest{
+		Key:                       map[string]AttributeValue{"id": AttributeValue{S: "new"}},
+		TableName:                 "bax",
+		UpdateExpression:          "ADD hits :one",
+		ExpressionAttributeValues: map[string]AttributeValue{":one": AttributeValue{N: "1"}},
+		ReturnValues:              AllNewReturnValues,
+	})
+	if err != nil || len(result.Attributes) != 2 || result.Attributes["hits"].N != "1" {
+		t.Fatalf("UpdateItem failed: %+v %v", result, err)
+	}
+
+	// Invalid updates
+
+	invalid := map[string]*UpdateItemRequest{
+		"Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [doc], path two: [doc, label]": &UpdateItemRequest{
+			UpdateExpression: "SET doc = :v REMOVE doc.label",
+		},
+		"Two document paths conflict with each other": &UpdateItemRequest{
+			UpdateExpression: "SET doc.entries[0] = :v, doc.entries.head = :v",
+		},
+		"Attribute name is a reserved keyword; reserved keyword: name": &UpdateItemRequest{
+			UpdateExpression: "SET doc.name = :v",
+		},
+		"Cannot update attribute id. This attribute is part of the key": &UpdateItemRequest{
+			UpdateExpression: "SET id = :v",
+		},
+		"An operand in the update expression has an incorrect data type": &UpdateItemRequest{
+			UpdateExpression: "SET hits = doc + :one",
+		},
+		"Incorrect operand type for operator or function; operator or function: +, operand type: S": &UpdateItemRequest{
+			UpdateExpression: "SET hits = hits + :v",
+		},
+		"Incorrect operand type for operator or function; operator: DELETE, operand type: N": &UpdateItemRequest{
+			UpdateExpression: "DELETE tags :one",
+		},
+		"The provided expression refers to an attribute that does not exist in the item": &UpdateItemRequest{
+			UpdateExpression: "SET hits = absent",
+		},
+		"The document path provided in the update expression is invalid for update": &UpdateItemRequest{
+			UpdateExpression: "SET absent.field = :v",
+		},
+		"The function is not allowed in an update expression; function: size": &UpdateItemRequest{
+			UpdateExpression: "SET hits = size(tags)",
+		},
+		"Non-expression parameters: {AttributeUpdates} Expression parameters: {UpdateExpression}": &UpdateItemRequest{
+			UpdateExpression: "SET hits = :one",
+			AttributeUpdates: map[string]AttributeValueUpdate{"foo": AttributeValueUpdate{Action: PutUpdateAction, Value: AttributeValue{S: "bar"}}},
+		},
+		"Non-expression parameters: {AttributeUpdates} Expression parameters: {ConditionExpression}": &UpdateItemRequest{
+			ConditionExpression: "attribute_exists(foo)",
+			AttributeUpdates:    map[string]AttributeValueUpdate{"foo": AttributeValueUpdate{Action: PutUpdateAction, Value: AttributeValue{S: "bar"}}},
+		},
+		"Non-expression parameters: {AttributeUpdates, Expected} Expression parameters: {UpdateExpression, ConditionExpression}": &UpdateItemRequest{
+			UpdateExpression:    "SET hits = :one",
+			ConditionExpression: "attribute_exists(foo)",
+			AttributeUpdates:    map[string]AttributeValueUpdate{"foo": AttributeValueUpdate{Action: PutUpdateAction, Value: AttributeValue{S: "bar"}}},
+			Expected:            map[string]ExpectedAttributeValue{"foo": ExpectedAttributeValue{Exists: true, Value: AttributeValue{S: "bar"}}},
+		},
+	}
+	values := map[string]AttributeValue{":v": AttributeValue{S: "v"}, ":one": AttributeValue{N: "1"}}
+	for message, req := range invalid {
+		req.Key, req.TableName = key, "bax"
+		req.ExpressionAttributeValues = make(map[string]AttributeValue)
+		for name, v := range values {
+			if strings.Contains(req.UpdateExpression, name) {
+				req.ExpressionAttributeValues[name] = v
+			}
+		}
+		_, err := table.UpdateItem(req)
+		if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") || !strings.Contains(err.Error(), message) {
+			t.Fatalf("%s: expected ValidationException with %q, got %v", req.UpdateExpression, message, err)
+		}
+	}
+
+	// Adding to an attribute of another type
+	_, err = table.UpdateItem(&UpdateItemRequest{
+		Key:                       key,
+		TableName:                 "bax",
+		UpdateExpression:          "ADD tags :one",
+		ExpressionAttributeValues: map[string]AttributeValue{":one": AttributeValue{N: "1"}},
+	})
+	if err == nil || errorMessage(err) != "An operand in the update expression has an incorrect data type" {
+		t.Fatalf("ADD to a set of another type should fail, got %v", err)
+	}
+}
+
+func TestProjectionExpression(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{
+		"id":   AttributeValue{S: "bar"},
+		"hits": AttributeValue{N: "1"},
+		"doc": AttributeValue{M: map[string]AttributeValue{
+			"entries": AttributeValue{L: []AttributeValue{
+				AttributeValue{S: "x"},
+				AttributeValue{S: "y"},
+				AttributeValue{M: map[string]AttributeValue{"head": AttributeValue{S: "z"}, "tail": AttributeValue{S: "w"}}},
+			}},
+			"name": AttributeValue{S: "doc"},
+		}},
+	})
+	key := map[string]AttributeValue{"id": AttributeValue{S: "bar"}}
+	names := map[string]string{"#d": "doc"}
+
+	checkItem := func(item map[string]AttributeValue) {
+		if len(item) != 2 || item["hits"].N != "1" || len(item["doc"].M) != 1 {
+			t.Fatalf("wrong projected item %+v", item)
+		}
+		entries := item["doc"].M["entries"].L
+		if len(entries) != 2 || entries[0].S != "x" || len(entries[1].M) != 1 || entries[1].M["head"].S != "z" {
+			t.Fatalf("wrong projected entries %+v", entries)
+		}
+	}
+
+	get, err := table.GetItem(&GetItemRequest{
+		Key:                      key,
+		TableName:                "bax",
+		ProjectionExpression:     "hits, #d.entries[2].head, #d.entries[0], absent.label",
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(get.Item)
+
+	scan, err := table.Scan(&ScanRequest{TableName: "bax", ProjectionExpression: "hits, #d.entries[0], #d.entries[2].head", ExpressionAttributeNames: names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(scan.Items[0])
+
+	query, err := table.Query(&QueryRequest{
+		TableName:                "bax",
+		KeyConditions:            map[string]Condition{"id": Condition{AttributeValueList: []AttributeValue{AttributeValue{S: "bar"}}, ConditionOperator: EQ}},
+		ProjectionExpression:     "hits, #d.entries[0], #d.entries[2].head",
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
+	checkItem(query.Items[0])
 
+	batch, err := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{
+		"bax": &KeysAndAttributes{Keys: []map[string]AttributeValue{key}, ProjectionExpression: "hits, #d.entries[0], #d.entries[2].head", ExpressionAttributeNames: names},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(batch.Responses["bax"][0])
+
+	transact, err := db.TransactGetItems(&TransactGetItemsRequest{TransactItems: []TransactGetItem{
+		{Get: &TransactGet{TableName: "bax", Key: key, ProjectionExpression: "hits, #d.entries[0], #d.entries[2].head", ExpressionAttributeNames: names}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(transact.Responses[0].Item)
+
+	// Invalid projections
+
+	for _, req := range []*GetItemRequest{
+		{Key: key, TableName: "bax", ProjectionExpression: "hits", AttributesToGet: []string{"hits"}},
+		{Key: key, TableName: "bax", ProjectionExpression: "doc.entries, doc.entries[0]"},
+		{Key: key, TableName: "bax", ProjectionExpression: "hits", ExpressionAttributeNames: names},
+		{Key: key, TableName: "bax", ProjectionExpression: "#x"},
+	} {
+		if _, err := table.GetItem(req); err == nil {
+			t.Fatalf("projection %q should fail", req.ProjectionExpression)
+		}
+	}
+	if _, err := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{
+		"bax": &KeysAndAttributes{Keys: []map[string]AttributeValue{key}, ProjectionExpression: "hits,"},
+	}}); err == nil {
+		t.Fatal("invalid batch projection should fail")
+	}
+	if _, err := table.Scan(&ScanRequest{TableName: "bax", ProjectionExpression: "hits", Select: AllAttributesQuerySelect}); err == nil {
+		t.Fatal("projection with ALL_ATTRIBUTES should fail")
+	}
 }
 
 func TestUpdateTable(t *testing.T) {
@@ -275,12 +784,12 @@ func TestUpdateTable(t *testing.T) {
 	table := db.GetTable("bax")
 
 	req := &CreateTableRequest{
-		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "foo", AttributeType: StringAttributeType}},
+		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "foo", AttributeType: StringAttributeType}, AttributeDefinition{AttributeName: "id", AttributeType: StringAttributeType}},
 		KeySchema:             []KeySchemaElement{KeySchemaElement{AttributeName: "id", KeyType: HashKeyType}},
 		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
 		TableName:             "bar",
 	}
-	tableDesc := db.CreateTable(req)
+	tableDesc, _ := db.CreateTable(req)
 
 	if tableDesc.TableDescription.ProvisionedThroughput.ReadCapacityUnits != 5 {
 		t.Fatalf("", tableDesc.TableDescription.ProvisionedThroughput.ReadCapacityUnits)
@@ -342,7 +851,6 @@ func TestUpdateTable(t *testing.T) {
 	}
 }
 
-
 func TestQuery(t *testing.T) {
 	db := NewDB()
 	CreateTable(db, "bax")
@@ -374,158 +882,726 @@ func TestQuery(t *testing.T) {
 		t.Fatalf("%+v", result)
 	}
 
-	// GT
+	// Only EQ can be used on the hash key
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{GT, []AttributeValue{AttributeValue{S: "ba"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	for _, operator := range []ConditionOperator{GT, GE, LT, LE, NE, IN, BETWEEN, BEGINS_WITH} {
+		values := []AttributeValue{AttributeValue{S: "ba"}}
+		if operator == BETWEEN {
+			values = append(values, AttributeValue{S: "bar"})
+		}
+		_, err := table.Query(&QueryRequest{
+			KeyConditions:   map[string]Condition{"id": Condition{operator, values}},
+			TableName:       "bax",
+			AttributesToGet: []string{"id", "foo"},
+		})
+		if err == nil || errorMessage(err) != "Query key condition not supported" {
+			t.Fatalf("%s on the hash key should fail, got %v", operator, err)
+		}
 	}
+}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+func TestQueryRange(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-03"}, "foo": AttributeValue{S: "bar3"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}, "foo": AttributeValue{S: "bar1"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-02-01"}, "foo": AttributeValue{S: "bar4"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}, "foo": AttributeValue{S: "bar2"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "baz"}, "date": AttributeValue{S: "2013-01-02"}, "foo": AttributeValue{S: "baz1"}})
+
+	cases := []struct {
+		condition Condition
+		expected  []string
+	}{
+		{Condition{}, []string{"bar1", "bar2", "bar3", "bar4"}},
+		{Condition{EQ, []AttributeValue{AttributeValue{S: "2013-01-02"}}}, []string{"bar2"}},
+		{Condition{LT, []AttributeValue{AttributeValue{S: "2013-01-03"}}}, []string{"bar1", "bar2"}},
+		{Condition{LE, []AttributeValue{AttributeValue{S: "2013-01-03"}}}, []string{"bar1", "bar2", "bar3"}},
+		{Condition{GT, []AttributeValue{AttributeValue{S: "2013-01-02"}}}, []string{"bar3", "bar4"}},
+		{Condition{GE, []AttributeValue{AttributeValue{S: "2013-01-02"}}}, []string{"bar2", "bar3", "bar4"}},
+		{Condition{BETWEEN, []AttributeValue{AttributeValue{S: "2013-01-02"}, AttributeValue{S: "2013-01-03"}}}, []string{"bar2", "bar3"}},
+		{Condition{BEGINS_WITH, []AttributeValue{AttributeValue{S: "2013-01"}}}, []string{"bar1", "bar2", "bar3"}},
+	}
+
+	for _, c := range cases {
+		conditions := map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}}
+		if c.condition.ConditionOperator != "" {
+			conditions["date"] = c.condition
+		}
+		result, err := table.Query(&QueryRequest{KeyConditions: conditions, TableName: "bax"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Items come back in range key order
+		if len(result.Items) != len(c.expected) {
+			t.Fatalf("%s: expected %v, got %+v", c.condition.ConditionOperator, c.expected, result.Items)
+		}
+		for i, foo := range c.expected {
+			if result.Items[i]["foo"].S != foo {
+				t.Fatalf("%s: expected %v, got %+v", c.condition.ConditionOperator, c.expected, result.Items)
+			}
+		}
+	}
+
+	// Invalid key conditions
+
+	hash := Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}
+	for _, c := range []struct {
+		conditions map[string]Condition
+		message    string
+	}{
+		{map[string]Condition{"date": Condition{EQ, []AttributeValue{AttributeValue{S: "2013"}}}}, "Query condition missed key schema element: id"},
+		{map[string]Condition{"id": hash, "date": Condition{NE, []AttributeValue{AttributeValue{S: "2013"}}}}, "Attempted conditional constraint is not an indexable operation"},
+		{map[string]Condition{"id": hash, "date": Condition{IN, []AttributeValue{AttributeValue{S: "2013"}}}}, "Attempted conditional constraint is not an indexable operation"},
+		{map[string]Condition{"id": hash, "date": Condition{CONTAINS, []AttributeValue{AttributeValue{S: "2013"}}}}, "Attempted conditional constraint is not an indexable operation"},
+		{map[string]Condition{"id": hash, "date": Condition{NOT_NULL, nil}}, "Attempted conditional constraint is not an indexable operation"},
+		{map[string]Condition{"id": hash, "date": Condition{BETWEEN, []AttributeValue{AttributeValue{S: "2013"}}}}, "One or more parameter values were invalid: Invalid number of argument(s) for the BETWEEN ComparisonOperator"},
+		{map[string]Condition{"id": Condition{EQ, nil}}, "One or more parameter values were invalid: Invalid number of argument(s) for the EQ ComparisonOperator"},
+		{map[string]Condition{"id": hash, "date": Condition{GT, []AttributeValue{AttributeValue{N: "2013"}}}}, "One or more parameter values were invalid: Condition parameter type does not match schema type"},
+		{map[string]Condition{"id": hash, "foo": Condition{EQ, []AttributeValue{AttributeValue{S: "bar1"}}}}, "Query condition missed key schema element: foo"},
+		{map[string]Condition{"id": hash, "date": Condition{GT, []AttributeValue{AttributeValue{S: "2013"}}}, "foo": Condition{EQ, []AttributeValue{AttributeValue{S: "bar1"}}}}, "Conditions can be of length 1 or 2 only"},
+	} {
+		_, err := table.Query(&QueryRequest{KeyConditions: c.conditions, TableName: "bax"})
+		if err == nil || errorMessage(err) != c.message {
+			t.Fatalf("%+v: expected %q, got %v", c.conditions, c.message, err)
+		}
 	}
+}
 
-	if result.Items[0]["foo"].S != "bam" || len(result.Items) != 2 {
-		t.Fatalf("%+v", result)
+func TestQueryNumberRange(t *testing.T) {
+	db := NewDB()
+	db.CreateTable(&CreateTableRequest{
+		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "id", AttributeType: StringAttributeType}, AttributeDefinition{AttributeName: "n", AttributeType: NumberAttributeType}},
+		KeySchema:             []KeySchemaElement{KeySchemaElement{AttributeName: "id", KeyType: HashKeyType}, KeySchemaElement{AttributeName: "n", KeyType: RangeKeyType}},
+		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
+		TableName:             "bax",
+	})
+	table := db.GetTable("bax")
+	for _, n := range []string{"10", "9", "-1", "100", "2.5"} {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "n": AttributeValue{N: n}, "foo": AttributeValue{S: n}})
 	}
 
-	// GE
-
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{GE, []AttributeValue{AttributeValue{S: "ba"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	result, err := table.Query(&QueryRequest{
+		KeyConditions: map[string]Condition{
+			"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}},
+			"n":  Condition{BETWEEN, []AttributeValue{AttributeValue{N: "2"}, AttributeValue{N: "10"}}},
+		},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+	expected := []string{"2.5", "9", "10"}
+	if len(result.Items) != len(expected) {
+		t.Fatalf("expected %v, got %+v", expected, result.Items)
+	}
+	for i, foo := range expected {
+		if result.Items[i]["foo"].S != foo {
+			t.Fatalf("expected %v, got %+v", expected, result.Items)
+		}
 	}
 
-	if result.Items[1]["foo"].S != "bar" || len(result.Items) != 3 {
-		t.Fatalf("%+v", result)
+	// Malformed key number
+
+	_, err = table.PutItem(&PutItemRequest{
+		Item:      map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "n": AttributeValue{N: "1O"}},
+		TableName: "bax",
+	})
+	if err == nil {
+		t.Fatalf("malformed number should be rejected")
 	}
+}
 
-	// LT
+func TestQueryLimit(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "ba"}, "foo": AttributeValue{S: "bar"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bat"}, "foo": AttributeValue{S: "bar2"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "at"}, "foo": AttributeValue{S: "bar3"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "1"}, "foo": AttributeValue{S: "bar4"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "2"}, "foo": AttributeValue{S: "bar5"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "3"}, "foo": AttributeValue{S: "bar6"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "4"}, "foo": AttributeValue{S: "bar7"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "5"}, "foo": AttributeValue{S: "bar8"}})
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{LT, []AttributeValue{AttributeValue{S: "bar"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+}
+
+func TestQueryPages(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for r := 0; r < 10; r++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: fmt.Sprint("d", r)}})
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "baz"}, "date": AttributeValue{S: fmt.Sprint("d", r)}})
+	}
+	keyConditions := map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}}
+
+	// Pages cover the items in range key order, both ways
+
+	for _, forward := range []bool{true, false} {
+		var dates []string
+		var startKey map[string]AttributeValue
+		pages := 0
+		for {
+			result, err := table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, Limit: 3, ExclusiveStartKey: startKey, ScanIndexForward: &forward})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages++
+			if result.Count > 3 || result.Count != result.ScannedCount {
+				t.Fatalf("wrong page %+v", result)
+			}
+			for _, item := range result.Items {
+				dates = append(dates, item["date"].S)
+			}
+			if result.LastEvaluatedKey == nil {
+				break
+			}
+			if len(result.LastEvaluatedKey) != 2 || result.LastEvaluatedKey["id"].S != "bar" {
+				t.Fatalf("LastEvaluatedKey should be the primary key, got %+v", result.LastEvaluatedKey)
+			}
+			startKey = result.LastEvaluatedKey
+		}
+		if pages != 4 || len(dates) != 10 {
+			t.Fatalf("expected 10 items in 4 pages, got %d in %d", len(dates), pages)
+		}
+		for i := 1; i < len(dates); i++ {
+			if forward && dates[i-1] >= dates[i] || !forward && dates[i-1] <= dates[i] {
+				t.Fatalf("items out of order (forward %v): %v", forward, dates)
+			}
+		}
+	}
+
+	// Range key conditions page both ways
+
+	for _, test := range []struct {
+		cond  Condition
+		dates string
+	}{
+		{Condition{EQ, []AttributeValue{AttributeValue{S: "d4"}}}, "d4"},
+		{Condition{LT, []AttributeValue{AttributeValue{S: "d3"}}}, "d0 d1 d2"},
+		{Condition{LE, []AttributeValue{AttributeValue{S: "d3"}}}, "d0 d1 d2 d3"},
+		{Condition{GT, []AttributeValue{AttributeValue{S: "d7"}}}, "d8 d9"},
+		{Condition{GE, []AttributeValue{AttributeValue{S: "d7"}}}, "d7 d8 d9"},
+		{Condition{BETWEEN, []AttributeValue{AttributeValue{S: "d2"}, AttributeValue{S: "d5"}}}, "d2 d3 d4 d5"},
+		{Condition{BEGINS_WITH, []AttributeValue{AttributeValue{S: "d1"}}}, "d1"},
+		{Condition{BEGINS_WITH, []AttributeValue{AttributeValue{S: "d"}}}, "d0 d1 d2 d3 d4 d5 d6 d7 d8 d9"},
+	} {
+		conditions := map[string]Condition{"id": keyConditions["id"], "date": test.cond}
+		for _, forward := range []bool{true, false} {
+			var dates []string
+			var startKey map[string]AttributeValue
+			for {
+				result, err := table.Query(&QueryRequest{TableName: "bax", KeyConditions: conditions, Limit: 2, ExclusiveStartKey: startKey, ScanIndexForward: &forward})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, item := range result.Items {
+					dates = append(dates, item["date"].S)
+				}
+				if result.LastEvaluatedKey == nil {
+					break
+				}
+				startKey = result.LastEvaluatedKey
+			}
+			if !forward {
+				for i, j := 0, len(dates)-1; i < j; i, j = i+1, j-1 {
+					dates[i], dates[j] = dates[j], dates[i]
+				}
+			}
+			if strings.Join(dates, " ") != test.dates {
+				t.Fatalf("%s (forward %v): expected %s, got %v", test.cond.ConditionOperator, forward, test.dates, dates)
+			}
+		}
+	}
+
+	// A page that reaches Limit has a LastEvaluatedKey even when it ends the
+	// partition, the next page is empty
+
+	result, err := table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 10 || result.LastEvaluatedKey["date"].S != "d9" {
+		t.Fatalf("expected a full page with a LastEvaluatedKey, got %d items and %+v", result.Count, result.LastEvaluatedKey)
+	}
+	result, err = table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, Limit: 10, ExclusiveStartKey: result.LastEvaluatedKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 0 || result.ScannedCount != 0 || result.LastEvaluatedKey != nil {
+		t.Fatalf("expected an empty last page, got %+v", result)
 	}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+	// The start key must be in the queried partition
+
+	other := map[string]AttributeValue{"id": AttributeValue{S: "baz"}, "date": AttributeValue{S: "d4"}}
+	for _, forward := range []bool{true, false} {
+		_, err = table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, ExclusiveStartKey: other, ScanIndexForward: &forward})
+		if err == nil || !strings.HasPrefix(err.Error(), "ValidationException: The provided starting key is invalid") {
+			t.Fatalf("expected an invalid starting key (forward %v), got %v", forward, err)
+		}
 	}
 
-	if result.Items[0]["foo"].S != "bar" || len(result.Items) != 7 {
-		t.Fatalf("%+v", len(result.Items), result)
+	// Select
+
+	result, err = table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, Select: CountQuerySelect, ReturnConsumedCapacity: TotalReturnConsumedCapacity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 10 || result.ScannedCount != 10 || result.Items != nil || result.ConsumedCapacity.CapacityUnits != 0.5 {
+		t.Fatalf("wrong count result %+v", result)
+	}
+	result, err = table.Query(&QueryRequest{TableName: "bax", KeyConditions: keyConditions, Select: SpecificAttributesAttributesQuerySelect, AttributesToGet: []string{"date"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 10 || len(result.Items[0]) != 1 {
+		t.Fatalf("wrong specific attributes %+v", result.Items)
+	}
+	for _, req := range []*QueryRequest{
+		{TableName: "bax", KeyConditions: keyConditions, Select: CountQuerySelect, AttributesToGet: []string{"date"}},
+		{TableName: "bax", KeyConditions: keyConditions, Select: AllProjectedAttributesQuerySelect},
+		{TableName: "bax", KeyConditions: keyConditions, Limit: -1},
+		{TableName: "bax", KeyConditions: keyConditions, ExclusiveStartKey: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}},
+	} {
+		if _, err := table.Query(req); err == nil {
+			t.Fatalf("query %+v should fail", req)
+		}
 	}
 
-	// LE
+	// Pages stop at 1MB
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{LE, []AttributeValue{AttributeValue{S: "bar"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	big := strings.Repeat("x", 300<<10)
+	for r := 0; r < 5; r++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "big"}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "blob": AttributeValue{S: big}})
+	}
+	result, err = table.Query(&QueryRequest{TableName: "bax", KeyConditions: map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "big"}}}}})
+	if err != nil {
+		t.Fatal(err)
 	}
+	if result.Count != 4 || result.LastEvaluatedKey["date"].S != "d3" {
+		t.Fatalf("expected a 1MB page of 4 items, got %d items and %+v", result.Count, result.LastEvaluatedKey)
+	}
+}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+func TestQueryLocalIndex(t *testing.T) {
+	db := NewDB()
+	_, err := db.CreateTable(&CreateTableRequest{
+		AttributeDefinitions: []AttributeDefinition{{AttributeName: "id", AttributeType: StringAttributeType}, {AttributeName: "date", AttributeType: StringAttributeType}, {AttributeName: "foo", AttributeType: StringAttributeType}},
+		KeySchema:            []KeySchemaElement{{AttributeName: "id", KeyType: HashKeyType}, {AttributeName: "date", KeyType: RangeKeyType}},
+		LocalSecondaryIndexes: []LocalSecondaryIndex{{
+			IndexName:  "fooIndex",
+			KeySchema:  []KeySchemaElement{{AttributeName: "id", KeyType: HashKeyType}, {AttributeName: "foo", KeyType: RangeKeyType}},
+			Projection: Projection{ProjectionType: IncludeProjectionType, NonKeyAttributes: []string{"extra"}},
+		}},
+		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
+		TableName:             "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := db.GetTable("bax")
+	for r := 0; r < 5; r++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "foo": AttributeValue{S: fmt.Sprint("f", 4-r)}, "extra": AttributeValue{S: "e"}, "hits": AttributeValue{N: "1"}})
+	}
+	// Items without the index key are not in the index
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "d9"}})
+
+	// Items come in index key order with the projected attributes, and
+	// pages resume after the index key
+
+	values := map[string]AttributeValue{":id": AttributeValue{S: "bar"}, ":f": AttributeValue{S: "f0"}}
+	var foos []string
+	var startKey map[string]AttributeValue
+	for {
+		result, err := table.Query(&QueryRequest{
+			TableName:                 "bax",
+			IndexName:                 "fooIndex",
+			KeyConditionExpression:    "id = :id AND foo > :f",
+			ExpressionAttributeValues: values,
+			Limit:                     2,
+			ExclusiveStartKey:         startKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range result.Items {
+			if len(item) != 4 || item["extra"].S != "e" {
+				t.Fatalf("wrong index projection %+v", item)
+			}
+			foos = append(foos, item["foo"].S)
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		if len(result.LastEvaluatedKey) != 3 || result.LastEvaluatedKey["foo"].S == "" {
+			t.Fatalf("LastEvaluatedKey should hold the index key, got %+v", result.LastEvaluatedKey)
+		}
+		startKey = result.LastEvaluatedKey
+	}
+	if strings.Join(foos, ",") != "f1,f2,f3,f4" {
+		t.Fatalf("wrong index order %v", foos)
+	}
+
+	foos, startKey = nil, nil
+	backward := false
+	for {
+		result, err := table.Query(&QueryRequest{
+			TableName:                 "bax",
+			IndexName:                 "fooIndex",
+			KeyConditionExpression:    "id = :id AND foo > :f",
+			ExpressionAttributeValues: values,
+			Limit:                     3,
+			ExclusiveStartKey:         startKey,
+			ScanIndexForward:          &backward,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range result.Items {
+			foos = append(foos, item["foo"].S)
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = result.LastEvaluatedKey
+	}
+	if strings.Join(foos, ",") != "f4,f3,f2,f1" {
+		t.Fatalf("wrong reverse index order %v", foos)
+	}
+
+	// All attributes are fetched from the table
+
+	result, err := table.Query(&QueryRequest{
+		TableName:                 "bax",
+		IndexName:                 "fooIndex",
+		KeyConditionExpression:    "id = :id",
+		ExpressionAttributeValues: map[string]AttributeValue{":id": AttributeValue{S: "bar"}},
+		Select:                    AllAttributesQuerySelect,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 5 || result.Items[0]["hits"].N != "1" {
+		t.Fatalf("wrong index query with all attributes %+v", result)
 	}
 
-	if result.Items[0]["foo"].S != "bam" || len(result.Items) != 8 {
-		t.Fatalf("%+v", len(result.Items), result)
+	for _, req := range []*QueryRequest{
+		{TableName: "bax", IndexName: "barIndex", KeyConditions: map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}}},
+		{TableName: "bax", IndexName: "fooIndex", KeyConditions: map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}}, ExclusiveStartKey: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "d1"}}},
+	} {
+		if _, err := table.Query(req); err == nil {
+			t.Fatalf("query %+v should fail", req)
+		}
 	}
+}
 
-	// NE
+func TestQueryFilterExpression(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for r := 0; r < 6; r++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "hits": AttributeValue{N: fmt.Sprint(r)}})
+	}
+	keyConditions := map[string]Condition{"id": Condition{AttributeValueList: []AttributeValue{AttributeValue{S: "bar"}}, ConditionOperator: EQ}}
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{NE, []AttributeValue{AttributeValue{S: "bar"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	result, err := table.Query(&QueryRequest{
+		TableName:                 "bax",
+		KeyConditions:             keyConditions,
+		FilterExpression:          "hits >= :min",
+		ExpressionAttributeValues: map[string]AttributeValue{":min": AttributeValue{N: "4"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 2 || result.ScannedCount != 6 || len(result.Items) != 2 || result.Items[0]["hits"].N != "4" {
+		t.Fatalf("wrong filtered query %+v", result)
 	}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+	// Key attributes can't be filtered on
+
+	_, err = table.Query(&QueryRequest{
+		TableName:                 "bax",
+		KeyConditions:             keyConditions,
+		FilterExpression:          "#d = :d",
+		ExpressionAttributeNames:  map[string]string{"#d": "date"},
+		ExpressionAttributeValues: map[string]AttributeValue{":d": AttributeValue{S: "d1"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Primary key attribute: date") {
+		t.Fatalf("filtering on a key attribute should fail, got %v", err)
 	}
+}
 
-	if result.Items[0]["foo"].S != "bar" || len(result.Items) != 8 {
-		t.Fatalf("%+v", len(result.Items), result)
+func TestQueryFilter(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for r := 0; r < 6; r++ {
+		InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: fmt.Sprint("d", r)}, "hits": AttributeValue{N: fmt.Sprint(r)}})
+	}
+	keyConditions := map[string]Condition{"id": Condition{EQ, []AttributeValue{AttributeValue{S: "bar"}}}}
+
+	result, err := table.Query(&QueryRequest{
+		TableName:     "bax",
+		KeyConditions: keyConditions,
+		QueryFilter: map[string]Condition{
+			"hits":  Condition{LT, []AttributeValue{AttributeValue{N: "1"}}},
+			"extra": Condition{NOT_NULL, nil},
+		},
+		ConditionalOperator: OrConditionalOperator,
+		Limit:               4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 1 || result.ScannedCount != 4 || result.Items[0]["hits"].N != "0" || result.LastEvaluatedKey == nil {
+		t.Fatalf("wrong filtered query %+v", result)
 	}
 
-	// IN
+	// Key attributes can't be filtered on
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{IN, []AttributeValue{AttributeValue{S: "bar"}, AttributeValue{S: "ba"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	_, err = table.Query(&QueryRequest{
+		TableName:     "bax",
+		KeyConditions: keyConditions,
+		QueryFilter:   map[string]Condition{"date": Condition{EQ, []AttributeValue{AttributeValue{S: "d1"}}}},
+	})
+	if err == nil || errorMessage(err) != "QueryFilter can only contain non-primary key attributes: Primary key attribute: date" {
+		t.Fatalf("filtering on a key attribute should fail, got %v", err)
 	}
+}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+func TestKeyConditionExpression(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	for _, id := range []string{"bar", "baz"} {
+		for r := 0; r < 6; r++ {
+			InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: id}, "date": AttributeValue{S: fmt.Sprint("d", r)}})
+		}
+	}
+	values := map[string]AttributeValue{
+		":id":  AttributeValue{S: "bar"},
+		":low": AttributeValue{S: "d1"},
+		":top": AttributeValue{S: "d3"},
+		":n":   AttributeValue{N: "1"},
+	}
+	query := func(expression string, placeholders ...string) (*QueryResult, error) {
+		req := &QueryRequest{TableName: "bax", KeyConditionExpression: expression, ExpressionAttributeValues: make(map[string]AttributeValue)}
+		if strings.Contains(expression, "#d") {
+			req.ExpressionAttributeNames = map[string]string{"#d": "date"}
+		}
+		for _, placeholder := range placeholders {
+			req.ExpressionAttributeValues[placeholder] = values[placeholder]
+		}
+		return table.Query(req)
+	}
+
+	for _, test := range []struct {
+		expression   string
+		placeholders []string
+		count        int
+	}{
+		{"id = :id", []string{":id"}, 6},
+		{"id = :id AND #d BETWEEN :low AND :top", []string{":id", ":low", ":top"}, 3},
+		{"(#d > :top) AND (id = :id)", []string{":id", ":top"}, 2},
+		{"id = :id AND begins_with(#d, :low)", []string{":id", ":low"}, 1},
+		{"id = :id AND #d <= :low", []string{":id", ":low"}, 2},
+	} {
+		result, err := query(test.expression, test.placeholders...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expression, err)
+		}
+		if result.Count != test.count {
+			t.Fatalf("%s: expected %d items, got %d", test.expression, test.count, result.Count)
+		}
+		for _, item := range result.Items {
+			if item["id"].S != "bar" {
+				t.Fatalf("%s: wrong item %+v", test.expression, item)
+			}
+		}
+	}
+
+	// Invalid key conditions are reported before unused placeholders
+
+	for expression, message := range map[string]string{
+		"#d = :low":                            "Query condition missed key schema element: id",
+		"id > :id":                             "Query key condition not supported",
+		"id = :id AND foo = :low":              "Query condition missed key schema element: foo",
+		"id = :id OR #d = :low":                "Invalid operator used in KeyConditionExpression: OR",
+		"id = :id AND #d <> :low":              "Invalid operator used in KeyConditionExpression: <>",
+		"id = :id AND #d IN (:low, :top)":      "Invalid operator used in KeyConditionExpression: IN",
+		"id = :id AND contains(#d, :low)":      "Invalid operator used in KeyConditionExpression: contains",
+		"id = :id AND attribute_exists(#d)":    "Invalid operator used in KeyConditionExpression: attribute_exists",
+		"id = :id AND id = :low":               "KeyConditionExpressions must only contain one condition per key",
+		"id = :id AND #d > :low AND #d < :top": "KeyConditionExpressions must only contain one condition per key",
+		"id = :id AND #d.x = :low":             "KeyConditionExpressions cannot have conditions on nested attributes",
+		"id = :id AND #d = #d":                 "A key condition must compare a key attribute to values",
+		"id = :id AND #d = :n":                 "Condition parameter type does not match schema type",
+	} {
+		_, err := query(expression, ":id", ":low", ":top", ":n")
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Fatalf("%s: expected %q, got %v", expression, message, err)
+		}
+	}
+
+	_, err := table.Query(&QueryRequest{
+		TableName:                 "bax",
+		KeyConditionExpression:    "id = :id",
+		KeyConditions:             map[string]Condition{"id": Condition{AttributeValueList: []AttributeValue{AttributeValue{S: "bar"}}, ConditionOperator: EQ}},
+		ExpressionAttributeValues: map[string]AttributeValue{":id": AttributeValue{S: "bar"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Can not use both expression and non-expression parameters") {
+		t.Fatalf("mixing KeyConditions and KeyConditionExpression should fail, got %v", err)
 	}
+}
 
-	if result.Items[0]["foo"].S != "bam" || len(result.Items) != 2 {
-		t.Fatalf("%+v", len(result.Items), result)
-	}
+func TestCompositeKeyItems(t *testing.T) {
+	db := NewDB()
+	CreateRangeTable(db, "bax")
+	table := db.GetTable("bax")
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}, "foo": AttributeValue{S: "bam"}})
+	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}, "foo": AttributeValue{S: "bom"}})
 
-	// BETWEEN
+	// Both items are kept
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{BETWEEN, []AttributeValue{AttributeValue{S: "2"}, AttributeValue{S: "4"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	result, err := table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Item["foo"].S != "bam" {
+		t.Fatalf("wrong item %+v", result.Item)
 	}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+	result, err = table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Item["foo"].S != "bom" {
+		t.Fatalf("wrong item %+v", result.Item)
 	}
 
-	if result.Items[0]["foo"].S != "bar5" || len(result.Items) != 3 {
-		t.Fatalf("%+v", len(result.Items), result)
+	// Update only touches one item
+
+	_, err = table.UpdateItem(&UpdateItemRequest{
+		Key:              map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}},
+		AttributeUpdates: map[string]AttributeValueUpdate{"foo": AttributeValueUpdate{Action: PutUpdateAction, Value: AttributeValue{S: "bim"}}},
+		TableName:        "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// BEGINS_WITH
+	result, _ = table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		TableName: "bax",
+	})
+	if result.Item["foo"].S != "bam" {
+		t.Fatalf("wrong item %+v", result.Item)
+	}
 
-	req = &QueryRequest{
-		KeyConditions:   map[string]Condition{"id": Condition{BEGINS_WITH, []AttributeValue{AttributeValue{S: "ba"}}}},
-		TableName:       "bax",
-		AttributesToGet: []string{"id", "foo"},
+	// Delete only removes one item
+
+	_, err = table.DeleteItem(&DeleteItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-01"}},
+		TableName: "bax",
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	result, _ = table.Query(req)
-	if result == nil {
-		t.Fail()
+	result, err = table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}},
+		TableName: "bax",
+	})
+	if err != nil || result.Item["foo"].S != "bim" {
+		t.Fatalf("wrong item %+v %v", result, err)
 	}
 
-	if result.Items[0]["foo"].S != "bam" || len(result.Items) != 3 {
-		t.Fatalf("%+v", len(result.Items), result)
+	// Missing range key
+
+	_, err = table.GetItem(&GetItemRequest{
+		Key:       map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+		TableName: "bax",
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") {
+		t.Fatalf("expected ValidationException, got %v", err)
+	}
+
+	_, err = table.PutItem(&PutItemRequest{
+		Item:      map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}},
+		TableName: "bax",
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") {
+		t.Fatalf("expected ValidationException, got %v", err)
+	}
+
+	// Keys hold the key attributes with their types and nothing else, full
+	// items are only written by PutItem
+
+	badKeys := []map[string]AttributeValue{
+		{"id": AttributeValue{S: "bar"}, "date": AttributeValue{N: "1"}},
+		{"id": AttributeValue{N: "1"}, "date": AttributeValue{S: "2013-01-02"}},
+		{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013-01-02"}, "foo": AttributeValue{S: "bim"}},
+	}
+	for _, key := range badKeys {
+		_, errGet := table.GetItem(&GetItemRequest{Key: key, TableName: "bax"})
+		_, errDelete := table.DeleteItem(&DeleteItemRequest{Key: key, TableName: "bax"})
+		_, errUpdate := table.UpdateItem(&UpdateItemRequest{Key: key, TableName: "bax"})
+		_, errBatchGet := db.BatchGetItem(&BatchGetItemRequest{RequestItems: map[string]*KeysAndAttributes{"bax": {Keys: []map[string]AttributeValue{key}}}})
+		_, errBatchWrite := db.BatchWriteItem(&BatchWriteItemRequest{RequestItems: map[string][]WriteRequest{"bax": {{DeleteRequest: &DeleteRequest{Key: key}}}}})
+		for _, err := range []error{errGet, errDelete, errUpdate, errBatchGet, errBatchWrite} {
+			if err == nil || err.Error() != "ValidationException: The provided key element does not match the schema" {
+				t.Fatalf("%+v: expected a key schema mismatch, got %v", key, err)
+			}
+		}
+	}
+	_, err = table.PutItem(&PutItemRequest{Item: badKeys[2], TableName: "bax"})
+	if err != nil {
+		t.Fatal(err)
 	}
 }
 
+func CreateRangeTable(db *DB, tableName string) *CreateTableResult {
+	req := &CreateTableRequest{
+		AttributeDefinitions:  []AttributeDefinition{AttributeDefinition{AttributeName: "id", AttributeType: StringAttributeType}, AttributeDefinition{AttributeName: "date", AttributeType: StringAttributeType}},
+		KeySchema:             []KeySchemaElement{KeySchemaElement{AttributeName: "id", KeyType: HashKeyType}, KeySchemaElement{AttributeName: "date", KeyType: RangeKeyType}},
+		ProvisionedThroughput: ProvisionedThroughput{ReadCapacityUnits: 5, WriteCapacityUnits: 5},
+		TableName:             tableName,
+	}
+	result, _ := db.CreateTable(req)
+	return result
+}
 
-func TestQueryLimit(t *testing.T) {
-	db := NewDB()
-	CreateTable(db, "bax")
-	table := db.GetTable("bax")
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "ba"}, "foo": AttributeValue{S: "bar"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bat"}, "foo": AttributeValue{S: "bar2"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "at"}, "foo": AttributeValue{S: "bar3"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "1"}, "foo": AttributeValue{S: "bar4"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "2"}, "foo": AttributeValue{S: "bar5"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "3"}, "foo": AttributeValue{S: "bar6"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "4"}, "foo": AttributeValue{S: "bar7"}})
-	InsertItem(table, "bax", map[string]AttributeValue{"id": AttributeValue{S: "5"}, "foo": AttributeValue{S: "bar8"}})
-	
+// ExpectItems checks items holds exactly one item for each of the string
+// values of attr, in any order.
+func ExpectItems(t *testing.T, items []map[string]AttributeValue, attr string, values ...string) {
+	if len(items) != len(values) {
+		t.Fatalf("expected %d items, got %d: %+v", len(values), len(items), items)
+	}
+	for _, value := range values {
+		found := false
+		for _, item := range items {
+			if item[attr].S == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("item with %s %q not found in %+v", attr, value, items)
+		}
+	}
 }
 
 func InsertItem(table *Table, tableName string, item map[string]AttributeValue) {
@@ -0,0 +1,553 @@
+package dynamockdb
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxTransactItems  = 100
+	maxTransactSize   = 4 << 20 // Bytes
+	idempotencyWindow = 10 * time.Minute
+)
+
+// timeNow is replaced in tests to expire client tokens.
+var timeNow = time.Now
+
+// transactWrite is an action of a transaction, validated and ready to be
+// checked and applied.
+type transactWrite struct {
+	table     *Table
+	key       itemKey
+	condition condition // nil when there is none
+	returnOld bool      // Return the item when the condition fails
+
+	put    map[string]AttributeValue // Item of a Put
+	update []updateAction            // Actions of an Update
+	delete bool
+}
+
+// TransactWriteItems applies up to 100 writes and condition checks on items
+// of one or more tables, all or nothing. The tables involved are locked for
+// the whole transaction, in name order so that concurrent transactions
+// can't deadlock.
+//
+// A transaction with a ClientRequestToken is applied once: submitting it
+// again within 10 minutes returns the result of the first submission.
+func (db *DB) TransactWriteItems(req *TransactWriteItemsRequest) (*TransactWriteItemsResult, error) {
+	if len(req.TransactItems) == 0 || len(req.TransactItems) > maxTransactItems {
+		return nil, validationErrorf("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d and greater than or equal to 1", maxTransactItems)
+	}
+
+	if req.ClientRequestToken != "" {
+		fingerprint, _ := json.Marshal(req)
+		result, err := db.tokens.begin(req.ClientRequestToken, string(fingerprint))
+		if result != nil || err != nil {
+			return result, err
+		}
+	}
+
+	result, err := db.transactWriteItems(req)
+	if req.ClientRequestToken != "" {
+		db.tokens.finish(req.ClientRequestToken, result)
+	}
+	return result, err
+}
+
+func (db *DB) transactWriteItems(req *TransactWriteItemsRequest) (*TransactWriteItemsResult, error) {
+	writes, err := db.prepareTransactWrites(req.TransactItems)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]*Table, len(writes))
+	ids := make([]string, len(writes))
+	for i, w := range writes {
+		tables[i], ids[i] = w.table, w.table.itemID(w.key)
+	}
+	if held := db.items.acquire(ids); held != nil {
+		return nil, transactionConflict(held)
+	}
+	defer db.items.release(ids)
+	unlock := lockTables(tables, true)
+	defer unlock()
+
+	// Check everything before writing anything
+	reasons := make([]CancellationReason, len(writes))
+	newItems := make([]map[string]AttributeValue, len(writes))
+	canceled := false
+	for i, w := range writes {
+		reasons[i], newItems[i] = w.check()
+		if reasons[i].Code != "None" {
+			canceled = true
+		}
+	}
+	if canceled {
+		return nil, transactionCanceled(reasons)
+	}
+
+	result := &TransactWriteItemsResult{}
+	consumed := make(map[string]float64)
+	for i, w := range writes {
+		tableName := w.table.TableDescription.TableName
+		old := w.table.lookup(w.key)
+		switch {
+		case w.put != nil || w.update != nil:
+			w.table.store(w.key, newItems[i])
+			consumed[tableName] += 2 * writeCapacity(maxInt(itemSize(old), itemSize(newItems[i])))
+		case w.delete:
+			w.table.remove(w.key)
+			consumed[tableName] += 2 * writeCapacity(itemSize(old))
+		default:
+			consumed[tableName] += 2 * readCapacity(itemSize(old), true)
+		}
+
+		isWrite := w.put != nil || w.update != nil || w.delete
+		if isWrite && req.ReturnItemCollectionMetrics == SizeReturnItemCollectionMetrics && len(w.table.TableDescription.LocalSecondaryIndexes) > 0 {
+			if result.ItemCollectionMetrics == nil {
+				result.ItemCollectionMetrics = make(map[string][]ItemCollectionMetrics)
+			}
+			result.ItemCollectionMetrics[tableName] = append(result.ItemCollectionMetrics[tableName], w.table.itemCollectionMetrics(w.key))
+		}
+	}
+	if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
+		result.ConsumedCapacity = sortedCapacity(consumed)
+	}
+
+	return result, nil
+}
+
+// prepareTransactWrites validates the actions of a transaction.
+func (db *DB) prepareTransactWrites(items []TransactWriteItem) ([]*transactWrite, error) {
+	writes := make([]*transactWrite, len(items))
+	seen := make(map[string]bool, len(items))
+	size := 0
+	for i, item := range items {
+		w, err := db.prepareTransactWrite(item)
+		if err != nil {
+			return nil, err
+		}
+		writes[i] = w
+
+		id := w.table.itemID(w.key)
+		if seen[id] {
+			return nil, validationErrorf("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+
+		size += itemSize(w.key.attributes(w.table)) + itemSize(w.put)
+		if size > maxTransactSize {
+			return nil, validationErrorf("Transaction request size cannot exceed 4 MB")
+		}
+	}
+	return writes, nil
+}
+
+func (db *DB) prepareTransactWrite(item TransactWriteItem) (*transactWrite, error) {
+	var tableName, conditionExpression string
+	var key map[string]AttributeValue
+	var attrs expressionAttributes
+	var returnValues ReturnValuesOnConditionCheckFailure
+	actions := 0
+	if c := item.ConditionCheck; c != nil {
+		actions++
+		tableName, key, conditionExpression, returnValues = c.TableName, c.Key, c.ConditionExpression, c.ReturnValuesOnConditionCheckFailure
+		attrs = newExpressionAttributes(c.ExpressionAttributeNames, c.ExpressionAttributeValues)
+	}
+	if d := item.Delete; d != nil {
+		actions++
+		tableName, key, conditionExpression, returnValues = d.TableName, d.Key, d.ConditionExpression, d.ReturnValuesOnConditionCheckFailure
+		attrs = newExpressionAttributes(d.ExpressionAttributeNames, d.ExpressionAttributeValues)
+	}
+	if p := item.Put; p != nil {
+		actions++
+		tableName, key, conditionExpression, returnValues = p.TableName, p.Item, p.ConditionExpression, p.ReturnValuesOnConditionCheckFailure
+		attrs = newExpressionAttributes(p.ExpressionAttributeNames, p.ExpressionAttributeValues)
+	}
+	if u := item.Update; u != nil {
+		actions++
+		tableName, key, conditionExpression, returnValues = u.TableName, u.Key, u.ConditionExpression, u.ReturnValuesOnConditionCheckFailure
+		attrs = newExpressionAttributes(u.ExpressionAttributeNames, u.ExpressionAttributeValues)
+	}
+	if actions != 1 {
+		return nil, validationErrorf("TransactItems can only contain one of Check, Put, Update or Delete")
+	}
+
+	table, err := db.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	w := &transactWrite{table: table, returnOld: returnValues == AllOldReturnValuesOnConditionCheckFailure}
+	if item.Put != nil {
+		w.key, err = table.keyOf(key)
+	} else {
+		w.key, err = table.parseKey(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if item.ConditionCheck != nil && conditionExpression == "" {
+		return nil, validationErrorf("The ConditionExpression of a ConditionCheck can not be empty")
+	}
+	if conditionExpression != "" {
+		if w.condition, err = parseCondition(conditionExpression, attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case item.Put != nil:
+		if w.put, err = normalizeItem(item.Put.Item); err != nil {
+			return nil, err
+		}
+	case item.Update != nil:
+		if w.update, err = parseUpdate(item.Update.UpdateExpression, attrs); err != nil {
+			return nil, err
+		}
+		for _, action := range w.update {
+			if table.isKeyAttribute(action.path[0].name) {
+				return nil, validationErrorf("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", action.path[0].name)
+			}
+		}
+	case item.Delete != nil:
+		w.delete = true
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// TransactGetItems reads up to 100 items of one or more tables as a
+// consistent snapshot. It is canceled if a transaction is writing some of
+// the items.
+func (db *DB) TransactGetItems(req *TransactGetItemsRequest) (*TransactGetItemsResult, error) {
+	if len(req.TransactItems) == 0 || len(req.TransactItems) > maxTransactItems {
+		return nil, validationErrorf("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d and greater than or equal to 1", maxTransactItems)
+	}
+
+	tables := make([]*Table, len(req.TransactItems))
+	keys := make([]itemKey, len(req.TransactItems))
+	projections := make([][]documentPath, len(req.TransactItems))
+	ids := make([]string, len(req.TransactItems))
+	seen := make(map[string]bool, len(req.TransactItems))
+	for i, item := range req.TransactItems {
+		get := item.Get
+		if get == nil {
+			return nil, validationErrorf("1 validation error detected: Value null at 'transactItems.%d.member.get' failed to satisfy constraint: Member must not be null", i+1)
+		}
+		table, err := db.table(get.TableName)
+		if err != nil {
+			return nil, err
+		}
+		if keys[i], err = table.parseKey(get.Key); err != nil {
+			return nil, err
+		}
+		attrs := newExpressionAttributes(get.ExpressionAttributeNames, nil)
+		if get.ProjectionExpression != "" {
+			if projections[i], err = parseProjection(get.ProjectionExpression, attrs); err != nil {
+				return nil, err
+			}
+		}
+		if err := attrs.checkUnused(); err != nil {
+			return nil, err
+		}
+		tables[i], ids[i] = table, table.itemID(keys[i])
+		if seen[ids[i]] {
+			return nil, validationErrorf("Transaction request cannot include multiple operations on one item")
+		}
+		seen[ids[i]] = true
+	}
+
+	if held := db.items.holding(ids); held != nil {
+		return nil, transactionConflict(held)
+	}
+	unlock := lockTables(tables, false)
+	defer unlock()
+
+	result := &TransactGetItemsResult{Responses: make([]ItemResponse, len(keys))}
+	consumed := make(map[string]float64)
+	for i, key := range keys {
+		item := tables[i].lookup(key)
+		if item != nil {
+			result.Responses[i].Item = projectItem(item, projections[i])
+		}
+		consumed[tables[i].TableDescription.TableName] += 2 * readCapacity(itemSize(item), true)
+	}
+	if req.ReturnConsumedCapacity == TotalReturnConsumedCapacity {
+		result.ConsumedCapacity = sortedCapacity(consumed)
+	}
+
+	return result, nil
+}
+
+// check evaluates the condition of w against the current item and returns
+// the item w would write. The table must be locked.
+func (w *transactWrite) check() (CancellationReason, map[string]AttributeValue) {
+	current := w.table.lookup(w.key)
+	if w.condition != nil && !w.condition.eval(current) {
+		reason := CancellationReason{Code: "ConditionalCheckFailed", Message: "The conditional request failed"}
+		if w.returnOld && current != nil {
+			reason.Item = copyItem(current)
+		}
+		return reason, nil
+	}
+
+	switch {
+	case w.put != nil:
+		return CancellationReason{Code: "None"}, w.put
+	case w.update != nil:
+		// Updating a missing item creates it
+		if current == nil {
+			current = w.key.attributes(w.table)
+		}
+		updated, err := applyUpdate(w.update, current)
+		if err != nil {
+			return CancellationReason{Code: "ValidationError", Message: errorMessage(err)}, nil
+		}
+		return CancellationReason{Code: "None"}, updated
+	}
+	return CancellationReason{Code: "None"}, nil
+}
+
+// transactionCanceled returns the error of a transaction canceled for
+// reasons, one for each of its actions.
+func transactionCanceled(reasons []CancellationReason) error {
+	codes := make([]string, len(reasons))
+	for i, reason := range reasons {
+		codes[i] = reason.Code
+	}
+	return &TransactionCanceledException{
+		Message:             "Transaction cancelled, please refer cancellation reasons for specific reasons [" + strings.Join(codes, ", ") + "]",
+		CancellationReasons: reasons,
+	}
+}
+
+// transactionConflict returns the error of a transaction canceled because
+// other transactions hold some of its items.
+func transactionConflict(held []bool) error {
+	reasons := make([]CancellationReason, len(held))
+	for i, h := range held {
+		reasons[i].Code = "None"
+		if h {
+			reasons[i] = CancellationReason{Code: "TransactionConflict", Message: "Transaction is ongoing for the item"}
+		}
+	}
+	return transactionCanceled(reasons)
+}
+
+// lockTables locks each of tables once, in name order, and returns the
+// function unlocking them. Tables are locked for writing if exclusive is
+// set, for reading otherwise.
+func lockTables(tables []*Table, exclusive bool) func() {
+	unique := make(map[string]*Table, len(tables))
+	names := make([]string, 0, len(tables))
+	for _, t := range tables {
+		name := t.TableDescription.TableName
+		if _, ok := unique[name]; !ok {
+			unique[name] = t
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if exclusive {
+			unique[name].mu.Lock()
+		} else {
+			unique[name].mu.RLock()
+		}
+	}
+	return func() {
+		for _, name := range names {
+			if exclusive {
+				unique[name].mu.Unlock()
+			} else {
+				unique[name].mu.RUnlock()
+			}
+		}
+	}
+}
+
+func sortedCapacity(consumed map[string]float64) []ConsumedCapacity {
+	capacity := make([]ConsumedCapacity, 0, len(consumed))
+	for tableName, units := range consumed {
+		capacity = append(capacity, ConsumedCapacity{CapacityUnits: units, TableName: tableName})
+	}
+	sort.Slice(capacity, func(i, j int) bool { return capacity[i].TableName < capacity[j].TableName })
+	return capacity
+}
+
+// itemLocks registers the items held by running transactions. Transactions
+// are isolated by the table locks, the registry only lets other
+// transactions notice the conflict and be canceled as they would be by
+// DynamoDB.
+type itemLocks struct {
+	mu     sync.Mutex
+	held   map[string]bool // See Table.itemID
+	forced map[string]bool // Items always in conflict, see ForceTransactionConflicts
+}
+
+// acquire holds the items ids for a transaction. If some of them are
+// already held it holds none and tells which are.
+func (l *itemLocks) acquire(ids []string) []bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held := l.check(ids); held != nil {
+		return held
+	}
+	if l.held == nil {
+		l.held = make(map[string]bool)
+	}
+	for _, id := range ids {
+		l.held[id] = true
+	}
+	return nil
+}
+
+func (l *itemLocks) release(ids []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		delete(l.held, id)
+	}
+}
+
+// holding tells which of the items ids are held, it returns nil if none is.
+func (l *itemLocks) holding(ids []string) []bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.check(ids)
+}
+
+func (l *itemLocks) check(ids []string) []bool {
+	var held []bool
+	for i, id := range ids {
+		if l.held[id] || l.forced[id] {
+			if held == nil {
+				held = make([]bool, len(ids))
+			}
+			held[i] = true
+		}
+	}
+	return held
+}
+
+// ForceTransactionConflicts makes the items at keys of table tableName
+// behave as if a transaction always held them, so that retries on
+// conflicts can be tested: transactions involving them are canceled,
+// PutItem, UpdateItem and DeleteItem on them fail with a
+// TransactionConflictException and BatchWriteItem leaves them unprocessed.
+func (db *DB) ForceTransactionConflicts(tableName string, keys ...map[string]AttributeValue) error {
+	table, err := db.table(tableName)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(keys))
+	for i, attrs := range keys {
+		key, err := table.keyOf(attrs)
+		if err != nil {
+			return err
+		}
+		ids[i] = table.itemID(key)
+	}
+
+	db.items.mu.Lock()
+	defer db.items.mu.Unlock()
+	if db.items.forced == nil {
+		db.items.forced = make(map[string]bool)
+	}
+	for _, id := range ids {
+		db.items.forced[id] = true
+	}
+	return nil
+}
+
+// ClearTransactionConflicts stops forcing the conflicts set with
+// ForceTransactionConflicts.
+func (db *DB) ClearTransactionConflicts() {
+	db.items.mu.Lock()
+	defer db.items.mu.Unlock()
+	db.items.forced = nil
+}
+
+// checkTransactions fails if a transaction holds the item at attrs, for
+// writes made outside of transactions.
+func (t *Table) checkTransactions(attrs map[string]AttributeValue) error {
+	if t.items == nil {
+		return nil
+	}
+	key, err := t.keyOf(attrs)
+	if err != nil {
+		return nil // Reported by the write
+	}
+	if t.heldByTransaction(key) {
+		return &TransactionConflictException{Message: "Transaction is ongoing for the item"}
+	}
+	return nil
+}
+
+// heldByTransaction tells if a transaction holds the item at key.
+func (t *Table) heldByTransaction(key itemKey) bool {
+	return t.items != nil && t.items.holding([]string{t.itemID(key)}) != nil
+}
+
+// clientTokens remembers the transactions submitted with a client token.
+type clientTokens struct {
+	mu      sync.Mutex
+	records map[string]*clientToken
+}
+
+type clientToken struct {
+	fingerprint string // The request, to detect a token reused for another one
+	expires     time.Time
+	result      *TransactWriteItemsResult // nil while the transaction runs
+}
+
+// begin registers a transaction submitted with token. It returns the result
+// of the previous submission if the transaction was already applied.
+func (c *clientTokens) begin(token, fingerprint string) (*TransactWriteItemsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := timeNow()
+	if c.records == nil {
+		c.records = make(map[string]*clientToken)
+	}
+	for t, record := range c.records {
+		if record.result != nil && now.After(record.expires) {
+			delete(c.records, t)
+		}
+	}
+
+	record, ok := c.records[token]
+	if !ok {
+		c.records[token] = &clientToken{fingerprint: fingerprint}
+		return nil, nil
+	}
+	if record.fingerprint != fingerprint {
+		return nil, &IdempotentParameterMismatchException{Message: "Transaction request cannot include the same ClientRequestToken with different parameters"}
+	}
+	if record.result == nil {
+		return nil, &TransactionInProgressException{Message: "Transaction with the same ClientRequestToken is in progress"}
+	}
+	return record.result, nil
+}
+
+// finish records the result of the transaction submitted with token. Failed
+// transactions are forgotten so they can be submitted again.
+func (c *clientTokens) finish(token string, result *TransactWriteItemsResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if result == nil {
+		delete(c.records, token)
+		return
+	}
+	c.records[token].result = result
+	c.records[token].expires = timeNow().Add(idempotencyWindow)
+}
@@ -0,0 +1,338 @@
+package dynamockdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactWriteItems(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	CreateRangeTable(db, "box")
+	bax, box := db.GetTable("bax"), db.GetTable("box")
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}})
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "gone"}})
+	InsertItem(box, "box", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: "bat"}})
+
+	result, err := db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{ConditionCheck: &TransactConditionCheck{
+				TableName:                 "box",
+				Key:                       map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}},
+				ConditionExpression:       "foo = :foo",
+				ExpressionAttributeValues: map[string]AttributeValue{":foo": AttributeValue{S: "bat"}},
+			}},
+			{Put: &TransactPut{
+				TableName:           "bax",
+				Item:                map[string]AttributeValue{"id": AttributeValue{S: "new"}, "foo": AttributeValue{S: "bag"}},
+				ConditionExpression: "attribute_not_exists(id)",
+			}},
+			{Update: &TransactUpdate{
+				TableName:                 "bax",
+				Key:                       map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+				UpdateExpression:          "SET #f = :foo",
+				ExpressionAttributeNames:  map[string]string{"#f": "foo"},
+				ExpressionAttributeValues: map[string]AttributeValue{":foo": AttributeValue{S: "baz"}},
+			}},
+			{Delete: &TransactDelete{
+				TableName: "bax",
+				Key:       map[string]AttributeValue{"id": AttributeValue{S: "gone"}},
+			}},
+		},
+		ReturnConsumedCapacity: TotalReturnConsumedCapacity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan, _ := bax.Scan(&ScanRequest{TableName: "bax"})
+	ExpectItems(t, scan.Items, "foo", "baz", "bag")
+	expectedCapacity := []ConsumedCapacity{{CapacityUnits: 6, TableName: "bax"}, {CapacityUnits: 2, TableName: "box"}}
+	if fmt.Sprint(result.ConsumedCapacity) != fmt.Sprint(expectedCapacity) {
+		t.Fatalf("wrong consumed capacity %+v", result.ConsumedCapacity)
+	}
+
+	// A failed condition cancels the whole transaction
+
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Put: &TransactPut{
+				TableName: "bax",
+				Item:      map[string]AttributeValue{"id": AttributeValue{S: "other"}},
+			}},
+			{Delete: &TransactDelete{
+				TableName:                           "box",
+				Key:                                 map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}},
+				ConditionExpression:                 "foo <> :foo",
+				ExpressionAttributeValues:           map[string]AttributeValue{":foo": AttributeValue{S: "bat"}},
+				ReturnValuesOnConditionCheckFailure: AllOldReturnValuesOnConditionCheckFailure,
+			}},
+		},
+	})
+	var canceled *TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		t.Fatalf("expected TransactionCanceledException, got %v", err)
+	}
+	if !strings.HasSuffix(canceled.Message, "[None, ConditionalCheckFailed]") {
+		t.Fatalf("wrong message %q", canceled.Message)
+	}
+	reasons := canceled.CancellationReasons
+	if len(reasons) != 2 || reasons[0].Code != "None" || reasons[1].Code != "ConditionalCheckFailed" || reasons[1].Item["foo"].S != "bat" {
+		t.Fatalf("wrong cancellation reasons %+v", reasons)
+	}
+	scan, _ = bax.Scan(&ScanRequest{TableName: "bax"})
+	ExpectItems(t, scan.Items, "foo", "baz", "bag")
+
+	// Invalid transactions
+
+	tooMany := make([]TransactWriteItem, maxTransactItems+1)
+	for i := range tooMany {
+		tooMany[i].Put = &TransactPut{TableName: "bax", Item: map[string]AttributeValue{"id": AttributeValue{S: fmt.Sprint(i)}}}
+	}
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{TransactItems: tooMany})
+	if err == nil || !strings.HasPrefix(err.Error(), "ValidationException") {
+		t.Fatalf("expected ValidationException for too many items, got %v", err)
+	}
+
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Put: &TransactPut{TableName: "bax", Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}},
+			{Delete: &TransactDelete{TableName: "bax", Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "multiple operations on one item") {
+		t.Fatalf("expected ValidationException for duplicate items, got %v", err)
+	}
+
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Update: &TransactUpdate{
+				TableName:                 "bax",
+				Key:                       map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+				UpdateExpression:          "SET id = :id",
+				ExpressionAttributeValues: map[string]AttributeValue{":id": AttributeValue{S: "baz"}},
+			}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "part of the key") {
+		t.Fatalf("expected ValidationException for key update, got %v", err)
+	}
+
+	var notFound *ResourceNotFoundException
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Put: &TransactPut{TableName: "nope", Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}},
+		},
+	})
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ResourceNotFoundException, got %v", err)
+	}
+}
+
+func TestTransactWriteItemsIdempotency(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	db := NewDB()
+	CreateTable(db, "bax")
+	bax := db.GetTable("bax")
+	add := &TransactWriteItemsRequest{
+		ClientRequestToken: "token",
+		TransactItems: []TransactWriteItem{
+			{Update: &TransactUpdate{
+				TableName:                 "bax",
+				Key:                       map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+				UpdateExpression:          "SET foo = :foo",
+				ExpressionAttributeValues: map[string]AttributeValue{":foo": AttributeValue{S: "bam"}},
+				ConditionExpression:       "attribute_not_exists(foo)",
+			}},
+		},
+	}
+
+	// Submitting again returns the first result without writing
+	for i := 0; i < 2; i++ {
+		if _, err := db.TransactWriteItems(add); err != nil {
+			t.Fatalf("submission %d: %v", i, err)
+		}
+	}
+
+	other := *add
+	other.TransactItems = []TransactWriteItem{
+		{Delete: &TransactDelete{TableName: "bax", Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}}},
+	}
+	var mismatch *IdempotentParameterMismatchException
+	if _, err := db.TransactWriteItems(&other); !errors.As(err, &mismatch) {
+		t.Fatalf("expected IdempotentParameterMismatchException, got %v", err)
+	}
+
+	// The token expires after 10 minutes
+	now = now.Add(idempotencyWindow + time.Second)
+	var canceled *TransactionCanceledException
+	if _, err := db.TransactWriteItems(add); !errors.As(err, &canceled) {
+		t.Fatalf("expected TransactionCanceledException once the token expired, got %v", err)
+	}
+
+	// A canceled transaction can be submitted again with its token
+	DeleteItemWithKey(bax, map[string]AttributeValue{"id": AttributeValue{S: "bar"}})
+	if _, err := db.TransactWriteItems(add); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactGetItems(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	CreateRangeTable(db, "box")
+	bax, box := db.GetTable("bax"), db.GetTable("box")
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}, "size": AttributeValue{N: "1"}})
+	InsertItem(box, "box", map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}, "foo": AttributeValue{S: "bat"}})
+
+	req := &TransactGetItemsRequest{
+		TransactItems: []TransactGetItem{
+			{Get: &TransactGet{
+				TableName:                "bax",
+				Key:                      map[string]AttributeValue{"id": AttributeValue{S: "bar"}},
+				ProjectionExpression:     "foo, #s",
+				ExpressionAttributeNames: map[string]string{"#s": "size"},
+			}},
+			{Get: &TransactGet{TableName: "bax", Key: map[string]AttributeValue{"id": AttributeValue{S: "missing"}}}},
+			{Get: &TransactGet{TableName: "box", Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}}}},
+		},
+		ReturnConsumedCapacity: TotalReturnConsumedCapacity,
+	}
+	result, err := db.TransactGetItems(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := result.Responses
+	if len(responses) != 3 || len(responses[0].Item) != 2 || responses[0].Item["size"].N != "1" || responses[1].Item != nil || responses[2].Item["foo"].S != "bat" {
+		t.Fatalf("wrong responses %+v", responses)
+	}
+	expectedCapacity := []ConsumedCapacity{{CapacityUnits: 4, TableName: "bax"}, {CapacityUnits: 2, TableName: "box"}}
+	if fmt.Sprint(result.ConsumedCapacity) != fmt.Sprint(expectedCapacity) {
+		t.Fatalf("wrong consumed capacity %+v", result.ConsumedCapacity)
+	}
+
+	// Items held by a transaction can't be read
+
+	key, _ := box.keyOf(req.TransactItems[2].Get.Key)
+	held := []string{box.itemID(key)}
+	db.items.acquire(held)
+	_, err = db.TransactGetItems(req)
+	var canceled *TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		t.Fatalf("expected TransactionCanceledException, got %v", err)
+	}
+	if !strings.HasSuffix(canceled.Message, "[None, None, TransactionConflict]") {
+		t.Fatalf("wrong message %q", canceled.Message)
+	}
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Delete: &TransactDelete{TableName: "box", Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "date": AttributeValue{S: "2013"}}}},
+		},
+	})
+	if !errors.As(err, &canceled) || canceled.CancellationReasons[0].Code != "TransactionConflict" {
+		t.Fatalf("expected TransactionCanceledException, got %v", err)
+	}
+	db.items.release(held)
+	if _, err := db.TransactGetItems(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid transactions
+
+	_, err = db.TransactGetItems(&TransactGetItemsRequest{
+		TransactItems: []TransactGetItem{req.TransactItems[1], req.TransactItems[1]},
+	})
+	if err == nil || !strings.Contains(err.Error(), "multiple operations on one item") {
+		t.Fatalf("expected ValidationException for duplicate items, got %v", err)
+	}
+
+	_, err = db.TransactGetItems(&TransactGetItemsRequest{
+		TransactItems: []TransactGetItem{
+			{Get: &TransactGet{TableName: "bax", Key: map[string]AttributeValue{"id": AttributeValue{S: "bar"}}, ProjectionExpression: "foo,"}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Invalid ProjectionExpression: Syntax error") {
+		t.Fatalf("expected ValidationException for the projection, got %v", err)
+	}
+}
+
+func TestTransactionConflicts(t *testing.T) {
+	db := NewDB()
+	CreateTable(db, "bax")
+	bax := db.GetTable("bax")
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "bar"}})
+	key := map[string]AttributeValue{"id": AttributeValue{S: "bar"}}
+
+	if err := db.ForceTransactionConflicts("bax", key); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *TransactionConflictException
+	_, err := bax.PutItem(&PutItemRequest{TableName: "bax", Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}}})
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected TransactionConflictException on PutItem, got %v", err)
+	}
+	_, err = bax.UpdateItem(&UpdateItemRequest{TableName: "bax", Key: key})
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected TransactionConflictException on UpdateItem, got %v", err)
+	}
+	_, err = bax.DeleteItem(&DeleteItemRequest{TableName: "bax", Key: key})
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected TransactionConflictException on DeleteItem, got %v", err)
+	}
+
+	// Batch writes leave the held items unprocessed
+	batch, err := db.BatchWriteItem(&BatchWriteItemRequest{RequestItems: map[string][]WriteRequest{"bax": {
+		{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bar"}, "foo": AttributeValue{S: "bam"}}}},
+		{PutRequest: &PutRequest{Item: map[string]AttributeValue{"id": AttributeValue{S: "bat"}}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unprocessed := batch.UnprocessedItems["bax"]; len(unprocessed) != 1 || unprocessed[0].PutRequest.Item["id"].S != "bar" {
+		t.Fatalf("expected the held item unprocessed, got %+v", batch.UnprocessedItems)
+	}
+	if result, _ := bax.GetItem(&GetItemRequest{TableName: "bax", Key: key}); result.Item["foo"].S != "" {
+		t.Fatalf("held item was written by a batch: %+v", result.Item)
+	}
+
+	// Other items and reads are not affected
+	InsertItem(bax, "bax", map[string]AttributeValue{"id": AttributeValue{S: "baz"}})
+	if result, err := bax.GetItem(&GetItemRequest{TableName: "bax", Key: key}); err != nil || result.Item == nil {
+		t.Fatalf("GetItem failed: %+v %v", result, err)
+	}
+
+	var canceled *TransactionCanceledException
+	_, err = db.TransactWriteItems(&TransactWriteItemsRequest{
+		TransactItems: []TransactWriteItem{
+			{Put: &TransactPut{TableName: "bax", Item: map[string]AttributeValue{"id": AttributeValue{S: "new"}}}},
+			{Delete: &TransactDelete{TableName: "bax", Key: key}},
+		},
+	})
+	if !errors.As(err, &canceled) || !strings.HasSuffix(canceled.Message, "[None, TransactionConflict]") {
+		t.Fatalf("expected TransactionCanceledException, got %v", err)
+	}
+
+	db.ClearTransactionConflicts()
+	if _, err := bax.DeleteItem(&DeleteItemRequest{TableName: "bax", Key: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.ForceTransactionConflicts("bax", map[string]AttributeValue{"foo": AttributeValue{S: "bar"}}); err == nil {
+		t.Fatal("expected ValidationException for an invalid key")
+	}
+
+	// A token can't be reused until its transaction is finished
+
+	db.tokens.begin("token", "fingerprint")
+	var inProgress *TransactionInProgressException
+	_, err = db.tokens.begin("token", "fingerprint")
+	if !errors.As(err, &inProgress) {
+		t.Fatalf("expected TransactionInProgressException, got %v", err)
+	}
+}
@@ -13,6 +13,10 @@ const (
 	NumberSetAttributeType               = "NS"
 	BinaryAttributeType                  = "B"
 	BinarySetAttributeType               = "BS"
+	MapAttributeType                     = "M"
+	ListAttributeType                    = "L"
+	BooleanAttributeType                 = "BOOL"
+	NullAttributeType                    = "NULL"
 )
 
 type AttributeDefinition struct {
@@ -32,20 +36,31 @@ const (
 // lengths greater than zero; and set type attributes must not be empty.
 // Requests with empty values will be rejected with a ValidationException.
 type AttributeValueUpdate struct {
-	Action UpdateAction
+	Action UpdateAction `json:",omitempty"`
 	Value  AttributeValue
 }
 
+type BatchGetItemRequest struct {
+	RequestItems           map[string]*KeysAndAttributes
+	ReturnConsumedCapacity ReturnConsumedCapacity `json:",omitempty"`
+}
+
 type BatchGetItemResult struct {
-	ConsumedCapacity []ConsumedCapacity
-	Responses        map[string]*KeysAndAttributes
+	ConsumedCapacity []ConsumedCapacity `json:",omitempty"`
+	Responses        map[string][]map[string]AttributeValue
 	UnprocessedKeys  map[string]*KeysAndAttributes
 }
 
+type BatchWriteItemRequest struct {
+	RequestItems                map[string][]WriteRequest
+	ReturnConsumedCapacity      ReturnConsumedCapacity      `json:",omitempty"`
+	ReturnItemCollectionMetrics ReturnItemCollectionMetrics `json:",omitempty"`
+}
+
 type BatchWriteItemResult struct {
-	ConsumedCapacity      []ConsumedCapacity
-	ItemCollectionMetrics map[string]ItemCollectionMetrics
-	UnprocessedItems      map[string]WriteRequest
+	ConsumedCapacity      []ConsumedCapacity                 `json:",omitempty"`
+	ItemCollectionMetrics map[string][]ItemCollectionMetrics `json:",omitempty"`
+	UnprocessedItems      map[string][]WriteRequest
 }
 
 type ConditionOperator string
@@ -66,9 +81,16 @@ const (
 	BEGINS_WITH                    = "BEGINS_WITH"
 )
 
+type ConditionalOperator string
+
+const (
+	AndConditionalOperator ConditionalOperator = "AND"
+	OrConditionalOperator                      = "OR"
+)
+
 type Condition struct {
-	ConditionOperator  ConditionOperator
-	AttributeValueList []AttributeValue
+	ConditionOperator  ConditionOperator `json:"ComparisonOperator"`
+	AttributeValueList []AttributeValue  `json:",omitempty"`
 }
 
 type ConsumedCapacity struct {
@@ -80,8 +102,8 @@ type CreateTableRequest struct {
 	AttributeDefinitions  []AttributeDefinition
 	KeySchema             []KeySchemaElement
 	ProvisionedThroughput ProvisionedThroughput
-	TableName             string // min 3 max 255
-	LocalSecondaryIndexes []LocalSecondaryIndex
+	TableName             string                // min 3 max 255
+	LocalSecondaryIndexes []LocalSecondaryIndex `json:",omitempty"`
 }
 
 type CreateTableResult struct {
@@ -89,18 +111,21 @@ type CreateTableResult struct {
 }
 
 type DeleteItemRequest struct {
-	Expected                    map[string]ExpectedAttributeValue
+	Expected                    map[string]ExpectedAttributeValue `json:",omitempty"`
+	ConditionExpression         string                            `json:",omitempty"`
+	ExpressionAttributeNames    map[string]string                 `json:",omitempty"`
+	ExpressionAttributeValues   map[string]AttributeValue         `json:",omitempty"`
 	Key                         map[string]AttributeValue
 	TableName                   string
-	ReturnConsumedCapacity      ReturnConsumedCapacity
-	ReturnItemCollectionMetrics ReturnItemCollectionMetrics
-	ReturnValues                ReturnValues
+	ReturnConsumedCapacity      ReturnConsumedCapacity      `json:",omitempty"`
+	ReturnItemCollectionMetrics ReturnItemCollectionMetrics `json:",omitempty"`
+	ReturnValues                ReturnValues                `json:",omitempty"`
 }
 
 type DeleteItemResult struct {
-	Attributes            map[string]AttributeValue
-	ConsumedCapacity      ConsumedCapacity
-	ItemCollectionMetrics ItemCollectionMetrics
+	Attributes            map[string]AttributeValue `json:",omitempty"`
+	ConsumedCapacity      *ConsumedCapacity         `json:",omitempty"`
+	ItemCollectionMetrics *ItemCollectionMetrics    `json:",omitempty"`
 }
 
 type DeleteRequest struct {
@@ -128,16 +153,18 @@ type ExpectedAttributeValue struct {
 }
 
 type GetItemRequest struct {
-	Key                    map[string]AttributeValue
-	TableName              string
-	AttributesToGet        []string
-	ConsistentRead         bool
-	ReturnConsumedCapacity ReturnConsumedCapacity
+	Key                      map[string]AttributeValue
+	TableName                string
+	AttributesToGet          []string               `json:",omitempty"`
+	ConsistentRead           bool                   `json:",omitempty"`
+	ExpressionAttributeNames map[string]string      `json:",omitempty"`
+	ProjectionExpression     string                 `json:",omitempty"`
+	ReturnConsumedCapacity   ReturnConsumedCapacity `json:",omitempty"`
 }
 
 type GetItemResult struct {
-	ConsumedCapacity ConsumedCapacity
-	Item             map[string]AttributeValue
+	ConsumedCapacity *ConsumedCapacity         `json:",omitempty"`
+	Item             map[string]AttributeValue `json:",omitempty"`
 }
 
 type ItemCollectionMetrics struct {
@@ -158,14 +185,16 @@ type KeySchemaElement struct {
 }
 
 type KeysAndAttributes struct {
-	Keys            []string
-	AttributesToGet []string
-	ConsistentRead  bool
+	Keys                     []map[string]AttributeValue
+	AttributesToGet          []string          `json:",omitempty"`
+	ConsistentRead           bool              `json:",omitempty"`
+	ExpressionAttributeNames map[string]string `json:",omitempty"`
+	ProjectionExpression     string            `json:",omitempty"`
 }
 
 type ListTablesRequest struct {
-	ExclusiveStartTableName string // min 3 max 255
-	Limit                   int
+	ExclusiveStartTableName string `json:",omitempty"` // min 3 max 255
+	Limit                   int    `json:",omitempty"`
 }
 
 type ListTablesResult struct {
@@ -175,7 +204,7 @@ type ListTablesResult struct {
 
 type LocalSecondaryIndex struct {
 	IndexName  string // min 3 max 255
-	KeySchema  KeySchemaElement
+	KeySchema  []KeySchemaElement
 	Projection Projection
 }
 
@@ -196,7 +225,7 @@ const (
 )
 
 type Projection struct {
-	NonKeyAttributes []string // min 1 item in list max 20
+	NonKeyAttributes []string `json:",omitempty"` // min 1 item in list max 20
 	ProjectionType   ProjectionType
 }
 
@@ -206,8 +235,8 @@ type ProvisionedThroughput struct {
 }
 
 type ProvisionedThroughputDescription struct {
-	LastDecreaseDateTime   time.Time
-	LastIncreaseDateTime   time.Time
+	LastDecreaseDateTime   time.Time // Seconds since the epoch in JSON
+	LastIncreaseDateTime   time.Time // Seconds since the epoch in JSON
 	NumberOfDecreasesToday float32
 	numberOfDecreasesDay   time.Time
 	ReadCapacityUnits      float32
@@ -241,16 +270,19 @@ const (
 type PutItemRequest struct {
 	Item                        map[string]AttributeValue
 	TableName                   string
-	Expected                    map[string]ExpectedAttributeValue
-	ReturnConsumedCapacity      ReturnConsumedCapacity
-	ReturnItemCollectionMetrics ReturnItemCollectionMetrics
-	ReturnValues                ReturnValues
+	Expected                    map[string]ExpectedAttributeValue `json:",omitempty"`
+	ConditionExpression         string                            `json:",omitempty"`
+	ExpressionAttributeNames    map[string]string                 `json:",omitempty"`
+	ExpressionAttributeValues   map[string]AttributeValue         `json:",omitempty"`
+	ReturnConsumedCapacity      ReturnConsumedCapacity            `json:",omitempty"`
+	ReturnItemCollectionMetrics ReturnItemCollectionMetrics       `json:",omitempty"`
+	ReturnValues                ReturnValues                      `json:",omitempty"`
 }
 
 type PutItemResult struct {
-	Attributes            map[string]AttributeValue
-	ConsumedCapacity      ConsumedCapacity
-	ItemCollectionMetrics ItemCollectionMetrics
+	Attributes            map[string]AttributeValue `json:",omitempty"`
+	ConsumedCapacity      *ConsumedCapacity         `json:",omitempty"`
+	ItemCollectionMetrics *ItemCollectionMetrics    `json:",omitempty"`
 }
 
 type PutRequest struct {
@@ -267,29 +299,56 @@ const (
 )
 
 type QueryRequest struct {
-	AttributesToGet        []string
-	ConsistentRead         bool
-	ExclusiveStartKey      map[string]AttributeValue // min 3 max 255
-	TableName              string
-	IndexName              string
-	KeyConditions          map[string]Condition
-	Limit                  int
-	ReturnConsumedCapacity ReturnConsumedCapacity
-	Select                 QuerySelect
+	AttributesToGet           []string                  `json:",omitempty"`
+	ConditionalOperator       ConditionalOperator       `json:",omitempty"`
+	ConsistentRead            bool                      `json:",omitempty"`
+	ExclusiveStartKey         map[string]AttributeValue `json:",omitempty"` // min 3 max 255
+	ExpressionAttributeNames  map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues map[string]AttributeValue `json:",omitempty"`
+	FilterExpression          string                    `json:",omitempty"`
+	TableName                 string
+	IndexName                 string                 `json:",omitempty"`
+	KeyConditionExpression    string                 `json:",omitempty"`
+	KeyConditions             map[string]Condition   `json:",omitempty"`
+	Limit                     int                    `json:",omitempty"`
+	ProjectionExpression      string                 `json:",omitempty"`
+	QueryFilter               map[string]Condition   `json:",omitempty"`
+	ReturnConsumedCapacity    ReturnConsumedCapacity `json:",omitempty"`
+	ScanIndexForward          *bool                  `json:",omitempty"` // Defaults to true
+	Select                    QuerySelect            `json:",omitempty"`
 }
 
 type QueryResult struct {
-	ConsumedCapacity ConsumedCapacity
+	ConsumedCapacity *ConsumedCapacity `json:",omitempty"`
 	Count            int
-	Items            []map[string]AttributeValue
-	LastEvaluatedKey map[string]AttributeValue
+	Items            []map[string]AttributeValue `json:",omitempty"`
+	LastEvaluatedKey map[string]AttributeValue   `json:",omitempty"`
+	ScannedCount     int
+}
+
+type ScanRequest struct {
+	AttributesToGet           []string                  `json:",omitempty"`
+	ConditionalOperator       ConditionalOperator       `json:",omitempty"`
+	ConsistentRead            bool                      `json:",omitempty"`
+	ExclusiveStartKey         map[string]AttributeValue `json:",omitempty"`
+	ExpressionAttributeNames  map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues map[string]AttributeValue `json:",omitempty"`
+	FilterExpression          string                    `json:",omitempty"`
+	Limit                     int                       `json:",omitempty"`
+	ProjectionExpression      string                    `json:",omitempty"`
+	ReturnConsumedCapacity    ReturnConsumedCapacity    `json:",omitempty"`
+	ScanFilter                map[string]Condition      `json:",omitempty"`
+	Segment                   int                       `json:",omitempty"`
+	Select                    QuerySelect               `json:",omitempty"`
+	TableName                 string
+	TotalSegments             int `json:",omitempty"`
 }
 
 type ScanResult struct {
-	ConsumedCapacity ConsumedCapacity
+	ConsumedCapacity *ConsumedCapacity `json:",omitempty"`
 	Count            int
-	Items            []string
-	LastEvaluatedKey map[string]AttributeValue
+	Items            []map[string]AttributeValue `json:",omitempty"`
+	LastEvaluatedKey map[string]AttributeValue   `json:",omitempty"`
 	ScannedCount     int
 }
 
@@ -304,30 +363,133 @@ const (
 
 type TableDescription struct {
 	AttributeDefinitions  []AttributeDefinition
-	CreationDateTime      time.Time
+	CreationDateTime      time.Time // Seconds since the epoch in JSON
 	ItemCount             float32
 	KeySchema             []KeySchemaElement
-	LocalSecondaryIndexes []LocalSecondaryIndex
+	LocalSecondaryIndexes []LocalSecondaryIndex `json:",omitempty"`
 	ProvisionedThroughput ProvisionedThroughputDescription
 	TableName             string // min 3 max 255
 	TableSizeBytes        float32
 	TableStatus           TableStatus
 }
 
+type ReturnValuesOnConditionCheckFailure string
+
+const (
+	AllOldReturnValuesOnConditionCheckFailure ReturnValuesOnConditionCheckFailure = "ALL_OLD"
+	NoneReturnValuesOnConditionCheckFailure                                       = "NONE"
+)
+
+type TransactConditionCheck struct {
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues           map[string]AttributeValue `json:",omitempty"`
+	Key                                 map[string]AttributeValue
+	ReturnValuesOnConditionCheckFailure ReturnValuesOnConditionCheckFailure `json:",omitempty"`
+	TableName                           string
+}
+
+type TransactDelete struct {
+	ConditionExpression                 string                    `json:",omitempty"`
+	ExpressionAttributeNames            map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues           map[string]AttributeValue `json:",omitempty"`
+	Key                                 map[string]AttributeValue
+	ReturnValuesOnConditionCheckFailure ReturnValuesOnConditionCheckFailure `json:",omitempty"`
+	TableName                           string
+}
+
+type TransactPut struct {
+	ConditionExpression                 string                    `json:",omitempty"`
+	ExpressionAttributeNames            map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues           map[string]AttributeValue `json:",omitempty"`
+	Item                                map[string]AttributeValue
+	ReturnValuesOnConditionCheckFailure ReturnValuesOnConditionCheckFailure `json:",omitempty"`
+	TableName                           string
+}
+
+type TransactUpdate struct {
+	ConditionExpression                 string                    `json:",omitempty"`
+	ExpressionAttributeNames            map[string]string         `json:",omitempty"`
+	ExpressionAttributeValues           map[string]AttributeValue `json:",omitempty"`
+	Key                                 map[string]AttributeValue
+	ReturnValuesOnConditionCheckFailure ReturnValuesOnConditionCheckFailure `json:",omitempty"`
+	TableName                           string
+	UpdateExpression                    string
+}
+
+// TransactWriteItem holds exactly one action.
+type TransactWriteItem struct {
+	ConditionCheck *TransactConditionCheck `json:",omitempty"`
+	Delete         *TransactDelete         `json:",omitempty"`
+	Put            *TransactPut            `json:",omitempty"`
+	Update         *TransactUpdate         `json:",omitempty"`
+}
+
+type TransactWriteItemsRequest struct {
+	ClientRequestToken          string                      `json:",omitempty"`
+	ReturnConsumedCapacity      ReturnConsumedCapacity      `json:",omitempty"`
+	ReturnItemCollectionMetrics ReturnItemCollectionMetrics `json:",omitempty"`
+	TransactItems               []TransactWriteItem
+}
+
+type TransactWriteItemsResult struct {
+	ConsumedCapacity      []ConsumedCapacity                 `json:",omitempty"`
+	ItemCollectionMetrics map[string][]ItemCollectionMetrics `json:",omitempty"`
+}
+
+// CancellationReason tells why an action of a transaction could not be
+// applied, or "None" for actions that were fine.
+type CancellationReason struct {
+	Code    string
+	Item    map[string]AttributeValue `json:",omitempty"`
+	Message string                    `json:",omitempty"`
+}
+
+type TransactGet struct {
+	ExpressionAttributeNames map[string]string `json:",omitempty"`
+	Key                      map[string]AttributeValue
+	ProjectionExpression     string `json:",omitempty"`
+	TableName                string
+}
+
+type TransactGetItem struct {
+	Get *TransactGet
+}
+
+type TransactGetItemsRequest struct {
+	ReturnConsumedCapacity ReturnConsumedCapacity `json:",omitempty"`
+	TransactItems          []TransactGetItem
+}
+
+// ItemResponse holds an item read by TransactGetItems, Item is empty when
+// the item doesn't exist.
+type ItemResponse struct {
+	Item map[string]AttributeValue `json:",omitempty"`
+}
+
+type TransactGetItemsResult struct {
+	ConsumedCapacity []ConsumedCapacity `json:",omitempty"`
+	Responses        []ItemResponse
+}
+
 type UpdateItemRequest struct {
-	AttributeUpdates            map[string]AttributeValueUpdate
+	AttributeUpdates            map[string]AttributeValueUpdate `json:",omitempty"`
 	TableName                   string
-	Expected                    map[string]ExpectedAttributeValue
+	Expected                    map[string]ExpectedAttributeValue `json:",omitempty"`
+	ConditionExpression         string                            `json:",omitempty"`
+	ExpressionAttributeNames    map[string]string                 `json:",omitempty"`
+	ExpressionAttributeValues   map[string]AttributeValue         `json:",omitempty"`
 	Key                         map[string]AttributeValue
-	ReturnConsumedCapacity      ReturnConsumedCapacity
-	ReturnItemCollectionMetrics ReturnItemCollectionMetrics
-	ReturnValues                ReturnValues
+	ReturnConsumedCapacity      ReturnConsumedCapacity      `json:",omitempty"`
+	ReturnItemCollectionMetrics ReturnItemCollectionMetrics `json:",omitempty"`
+	ReturnValues                ReturnValues                `json:",omitempty"`
+	UpdateExpression            string                      `json:",omitempty"`
 }
 
 type UpdateItemResult struct {
-	Attributes            map[string]AttributeValue
-	ConsumedCapacity      ConsumedCapacity
-	ItemCollectionMetrics ItemCollectionMetrics
+	Attributes            map[string]AttributeValue `json:",omitempty"`
+	ConsumedCapacity      *ConsumedCapacity         `json:",omitempty"`
+	ItemCollectionMetrics *ItemCollectionMetrics    `json:",omitempty"`
 }
 
 type UpdateTableRequest struct {
@@ -340,6 +502,6 @@ type UpdateTableResult struct {
 }
 
 type WriteRequest struct {
-	DeleteRequest DeleteRequest
-	PutRequest    PutRequest
+	DeleteRequest *DeleteRequest `json:",omitempty"`
+	PutRequest    *PutRequest    `json:",omitempty"`
 }
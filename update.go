@@ -0,0 +1,410 @@
+package dynamockdb
+
+import "strings"
+
+// updateAction is a single action of an UpdateExpression.
+type updateAction struct {
+	action  string // SET, REMOVE, ADD or DELETE
+	path    documentPath
+	value   updateValue    // For SET
+	operand AttributeValue // For ADD and DELETE
+}
+
+// parseUpdate parses an UpdateExpression. Each clause can be used once and
+// no two actions can touch overlapping paths:
+//
+//	update  := ( SET set ( , set )* | REMOVE path ( , path )*
+//	           | ADD path :value ( , path :value )* | DELETE path :value ( , path :value )* )+
+//	set     := path = value
+//	value   := operand | operand + operand | operand - operand
+//	operand := path | :value | if_not_exists ( path , operand ) | list_append ( operand , operand )
+func parseUpdate(expression string, attrs expressionAttributes) ([]updateAction, error) {
+	p, err := newExpressionParser("UpdateExpression", expression, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []updateAction
+	seen := make(map[string]bool)
+	for p.peek().kind != tokenEOF {
+		clause := strings.ToUpper(p.peek().text)
+		switch {
+		case p.peek().kind != tokenName:
+			return nil, p.syntaxError()
+		case clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE":
+			return nil, p.syntaxError()
+		case seen[clause]:
+			return nil, validationErrorf("Invalid UpdateExpression: The \"%s\" section can only be used once in an update expression;", clause)
+		}
+		seen[clause] = true
+		p.next()
+
+		for {
+			action, err := p.parseUpdateAction(clause)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, action)
+
+			if !p.isSymbol(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.checkPaths(updatedPaths(actions)); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (p *expressionParser) parseUpdateAction(clause string) (updateAction, error) {
+	path, err := p.parsePath()
+	if err != nil {
+		return updateAction{}, err
+	}
+	action := updateAction{action: clause, path: path}
+
+	switch clause {
+	case "SET":
+		if err := p.expectSymbol("="); err != nil {
+			return updateAction{}, err
+		}
+		action.value, err = p.parseUpdateValue()
+	case "ADD", "DELETE":
+		// Sets for both, numbers too for ADD
+		if p.peek().kind != tokenValueRef {
+			return updateAction{}, p.syntaxError()
+		}
+		o, err := p.parseOperand()
+		if err != nil {
+			return updateAction{}, err
+		}
+		v := o.(valueOperand)
+		switch {
+		case v.value.Type() == NumberAttributeType && clause == "ADD":
+		case v.value.Type() == StringSetAttributeType, v.value.Type() == NumberSetAttributeType, v.value.Type() == BinarySetAttributeType:
+		default:
+			return updateAction{}, validationErrorf("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: %s, operand type: %s", clause, v.value.Type())
+		}
+		action.operand = v.value
+	}
+	return action, err
+}
+
+func (p *expressionParser) parseUpdateValue() (updateValue, error) {
+	left, err := p.parseUpdateOperand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isSymbol("+") && !p.isSymbol("-") {
+		return left, nil
+	}
+	op := p.next().text
+	right, err := p.parseUpdateOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range []updateValue{left, right} {
+		o, ok := o.(operandValue)
+		if !ok {
+			continue
+		}
+		if v, ok := o.operand.(valueOperand); ok && v.value.Type() != NumberAttributeType {
+			return nil, validationErrorf("Invalid UpdateExpression: Incorrect operand type for operator or function; operator or function: %s, operand type: %s", op, v.value.Type())
+		}
+	}
+	return arithmetic{op, left, right}, nil
+}
+
+func (p *expressionParser) parseUpdateOperand() (updateValue, error) {
+	if !p.isFunction() {
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return operandValue{o}, nil
+	}
+
+	function := p.next().text
+	p.next() // (
+	var args []updateValue
+	for {
+		arg, err := p.parseUpdateOperand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	switch function {
+	case "if_not_exists", "list_append":
+		if len(args) != 2 {
+			return nil, validationErrorf("Invalid UpdateExpression: Incorrect number of operands for operator or function; operator or function: %s, number of operands: %d", function, len(args))
+		}
+	case "attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains", "size":
+		return nil, validationErrorf("Invalid UpdateExpression: The function is not allowed in an update expression; function: %s", function)
+	default:
+		return nil, validationErrorf("Invalid UpdateExpression: Invalid function name; function: %s", function)
+	}
+	if function == "list_append" {
+		return listAppend{args[0], args[1]}, nil
+	}
+	o, ok := args[0].(operandValue)
+	path, isPath := o.operand.(documentPath)
+	if !ok || !isPath {
+		return nil, validationErrorf("Invalid UpdateExpression: Operator or function requires a document path; operator or function: %s", function)
+	}
+	return ifNotExists{path, args[1]}, nil
+}
+
+// updateValue is the value a SET action assigns.
+type updateValue interface {
+	// evaluate returns the value for item, the item before the update.
+	evaluate(item map[string]AttributeValue) (AttributeValue, error)
+}
+
+func incorrectOperandType() error {
+	return validationErrorf("An operand in the update expression has an incorrect data type")
+}
+
+type operandValue struct {
+	operand operand
+}
+
+func (v operandValue) evaluate(item map[string]AttributeValue) (AttributeValue, error) {
+	value, ok := v.operand.resolve(item)
+	if !ok {
+		return AttributeValue{}, validationErrorf("The provided expression refers to an attribute that does not exist in the item")
+	}
+	return value, nil
+}
+
+type ifNotExists struct {
+	path  documentPath
+	value updateValue
+}
+
+func (v ifNotExists) evaluate(item map[string]AttributeValue) (AttributeValue, error) {
+	if value, ok := v.path.resolve(item); ok {
+		return value, nil
+	}
+	return v.value.evaluate(item)
+}
+
+type listAppend struct {
+	a, b updateValue
+}
+
+func (v listAppend) evaluate(item map[string]AttributeValue) (AttributeValue, error) {
+	a, err := v.a.evaluate(item)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	b, err := v.b.evaluate(item)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	if a.Type() != ListAttributeType || b.Type() != ListAttributeType {
+		return AttributeValue{}, incorrectOperandType()
+	}
+	l := make([]AttributeValue, 0, len(a.L)+len(b.L))
+	return AttributeValue{L: append(append(l, a.L...), b.L...)}, nil
+}
+
+type arithmetic struct {
+	op   string
+	a, b updateValue
+}
+
+func (v arithmetic) evaluate(item map[string]AttributeValue) (AttributeValue, error) {
+	a, err := v.a.evaluate(item)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	b, err := v.b.evaluate(item)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	if a.Type() != NumberAttributeType || b.Type() != NumberAttributeType {
+		return AttributeValue{}, incorrectOperandType()
+	}
+	n, err := ParseNumber(a.N)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	m, err := ParseNumber(b.N)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	var result Number
+	if v.op == "+" {
+		result, err = n.Add(m)
+	} else {
+		result, err = n.Sub(m)
+	}
+	if err != nil {
+		return AttributeValue{}, err
+	}
+	return AttributeValue{N: result.String()}, nil
+}
+
+// applyUpdate returns a copy of item with actions applied. Values are
+// computed from item as it was before the update.
+func applyUpdate(actions []updateAction, item map[string]AttributeValue) (map[string]AttributeValue, error) {
+	updated := copyItem(item)
+	removed := false
+	for _, action := range actions {
+		var err error
+		switch action.action {
+		case "SET":
+			var v AttributeValue
+			if v, err = action.value.evaluate(item); err == nil {
+				updated, err = updatePath(updated, action.path, &v)
+			}
+		case "REMOVE":
+			updated, err = updatePath(updated, action.path, nil)
+			removed = true
+		case "ADD":
+			var old *AttributeValue
+			if v, ok := action.path.resolve(item); ok {
+				old = &v
+			}
+			var v AttributeValue
+			if v, err = action.operand.AddTo(old); err == nil {
+				updated, err = updatePath(updated, action.path, &v)
+			}
+		case "DELETE":
+			old, ok := action.path.resolve(item)
+			if !ok {
+				continue
+			}
+			var v AttributeValue
+			if v, err = action.operand.DeleteFromSet(old); err != nil {
+				break
+			}
+			if v.Type() == "" {
+				updated, err = updatePath(updated, action.path, nil)
+				removed = true
+			} else {
+				updated, err = updatePath(updated, action.path, &v)
+			}
+		}
+		if err != nil {
+			if err == errTypeMismatch {
+				return nil, incorrectOperandType()
+			}
+			return nil, err
+		}
+	}
+	if removed {
+		updated = compactLists(AttributeValue{M: updated}).M
+	}
+	return updated, nil
+}
+
+// updatePath returns item with the value at path set to v, or removed if v
+// is nil. Maps and lists along path are copied, item is modified in place.
+//
+// Removed list elements are left empty so that the indexes of the other
+// actions still hold, see compactLists.
+func updatePath(item map[string]AttributeValue, path documentPath, v *AttributeValue) (map[string]AttributeValue, error) {
+	root, err := updateIn(AttributeValue{M: item}, path, v)
+	return root.M, err
+}
+
+func updateIn(parent AttributeValue, path documentPath, v *AttributeValue) (AttributeValue, error) {
+	invalidPath := validationErrorf("The document path provided in the update expression is invalid for update")
+	e := path[0]
+
+	if e.index < 0 {
+		if parent.Type() != MapAttributeType {
+			return parent, invalidPath
+		}
+		m := copyItem(parent.M)
+		if len(path) == 1 {
+			if v == nil {
+				delete(m, e.name)
+			} else {
+				m[e.name] = *v
+			}
+			return AttributeValue{M: m}, nil
+		}
+		child, ok := m[e.name]
+		if !ok {
+			return parent, invalidPath
+		}
+		child, err := updateIn(child, path[1:], v)
+		if err != nil {
+			return parent, err
+		}
+		m[e.name] = child
+		return AttributeValue{M: m}, nil
+	}
+
+	if parent.Type() != ListAttributeType {
+		return parent, invalidPath
+	}
+	l := append([]AttributeValue{}, parent.L...)
+	if len(path) == 1 {
+		switch {
+		case v == nil:
+			if e.index < len(l) {
+				l[e.index] = AttributeValue{}
+			}
+		case e.index >= len(l):
+			// Setting past the end appends
+			l = append(l, *v)
+		default:
+			l[e.index] = *v
+		}
+		return AttributeValue{L: l}, nil
+	}
+	if e.index >= len(l) {
+		return parent, invalidPath
+	}
+	child, err := updateIn(l[e.index], path[1:], v)
+	if err != nil {
+		return parent, err
+	}
+	l[e.index] = child
+	return AttributeValue{L: l}, nil
+}
+
+// compactLists drops the list elements left empty by updatePath.
+func compactLists(v AttributeValue) AttributeValue {
+	switch v.Type() {
+	case MapAttributeType:
+		m := make(map[string]AttributeValue, len(v.M))
+		for k, e := range v.M {
+			m[k] = compactLists(e)
+		}
+		return AttributeValue{M: m}
+	case ListAttributeType:
+		l := make([]AttributeValue, 0, len(v.L))
+		for _, e := range v.L {
+			if e.Type() != "" {
+				l = append(l, compactLists(e))
+			}
+		}
+		return AttributeValue{L: l}
+	}
+	return v
+}
+
+// updatedPaths returns the paths touched by actions, see projectPaths.
+func updatedPaths(actions []updateAction) []documentPath {
+	paths := make([]documentPath, len(actions))
+	for i, action := range actions {
+		paths[i] = action.path
+	}
+	return paths
+}